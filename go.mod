@@ -3,6 +3,7 @@ module github.com/riete/go-guac
 go 1.25.3
 
 require (
+	github.com/coder/websocket v1.8.14
 	github.com/gorilla/websocket v1.5.3
 	github.com/riete/convert v0.0.3
 )
@@ -0,0 +1,153 @@
+// Package metrics exports the measurements of tunnels created with tunnel.WithMetrics
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/riete/go-guac/protocol"
+	"github.com/riete/go-guac/tunnel"
+)
+
+const subsystem = "guac"
+
+// otherOpcode labels instructions with an opcode which is not documented, keeping the number of series bounded
+const otherOpcode = "other"
+
+// PrometheusOption configures NewPrometheus
+type PrometheusOption func(p *Prometheus)
+
+// WithNamespace prefixes the metric names, e.g. myapp_guac_sessions_total
+func WithNamespace(namespace string) PrometheusOption {
+	return func(p *Prometheus) {
+		p.namespace = namespace
+	}
+}
+
+// WithConstLabels adds labels with fixed values to all metrics, e.g. the instance
+func WithConstLabels(labels prometheus.Labels) PrometheusOption {
+	return func(p *Prometheus) {
+		p.constLabels = labels
+	}
+}
+
+// WithDurationBuckets sets the buckets of the session duration histogram in seconds
+func WithDurationBuckets(buckets []float64) PrometheusOption {
+	return func(p *Prometheus) {
+		p.durationBuckets = buckets
+	}
+}
+
+// Prometheus aggregates the measurements of all tunnels by protocol. It implements both tunnel.Metrics
+// and prometheus.Collector, pass it to tunnel.WithMetrics and register it with a prometheus.Registerer
+type Prometheus struct {
+	namespace       string
+	constLabels     prometheus.Labels
+	durationBuckets []float64
+
+	active       *prometheus.GaugeVec
+	sessions     *prometheus.CounterVec
+	closed       *prometheus.CounterVec
+	handshakes   *prometheus.HistogramVec
+	durations    *prometheus.HistogramVec
+	bytes        *prometheus.CounterVec
+	instructions *prometheus.CounterVec
+	roundTrips   *prometheus.HistogramVec
+}
+
+// Handshake observes the handshake latency by protocol and result
+func (p *Prometheus) Handshake(t *tunnel.Tunnel, latency time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	p.handshakes.WithLabelValues(protocolLabel(t), result).Observe(latency.Seconds())
+}
+
+// SessionStarted counts the session and adds it to the active sessions
+func (p *Prometheus) SessionStarted(t *tunnel.Tunnel) {
+	p.sessions.WithLabelValues(protocolLabel(t)).Inc()
+	p.active.WithLabelValues(protocolLabel(t)).Inc()
+}
+
+// Instruction counts the forwarded bytes and instructions, opcodes which are not documented are labelled other
+func (p *Prometheus) Instruction(t *tunnel.Tunnel, direction tunnel.Direction, opcode string, size int) {
+	if !protocol.IsOpcode(opcode) {
+		opcode = otherOpcode
+	}
+	p.bytes.WithLabelValues(protocolLabel(t), direction.String()).Add(float64(size))
+	p.instructions.WithLabelValues(protocolLabel(t), direction.String(), opcode).Inc()
+}
+
+// RoundTrip observes the time the client took to answer a sync
+func (p *Prometheus) RoundTrip(t *tunnel.Tunnel, latency time.Duration) {
+	p.roundTrips.WithLabelValues(protocolLabel(t)).Observe(latency.Seconds())
+}
+
+// SessionEnded removes the session from the active sessions, observes its duration and counts its close status
+func (p *Prometheus) SessionEnded(t *tunnel.Tunnel, duration time.Duration, status protocol.StatusCode) {
+	p.active.WithLabelValues(protocolLabel(t)).Dec()
+	p.durations.WithLabelValues(protocolLabel(t)).Observe(duration.Seconds())
+	p.closed.WithLabelValues(protocolLabel(t), status.String()).Inc()
+}
+
+func (p *Prometheus) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		p.active, p.sessions, p.closed, p.handshakes, p.durations, p.bytes, p.instructions, p.roundTrips,
+	}
+}
+
+// Describe implements prometheus.Collector
+func (p *Prometheus) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range p.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (p *Prometheus) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range p.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// protocolLabel returns the protocol of a tunnel, the config of tunnels joining a connection does not select one
+func protocolLabel(t *tunnel.Tunnel) string {
+	if t.Joined() {
+		return "join"
+	}
+	return t.Protocol()
+}
+
+// NewPrometheus creates the collector, metrics are named guac_* unless a namespace is set
+func NewPrometheus(opts ...PrometheusOption) *Prometheus {
+	p := &Prometheus{durationBuckets: []float64{60, 300, 900, 1800, 3600, 7200, 14400, 28800}}
+	for _, opt := range opts {
+		opt(p)
+	}
+	counter := func(name, help string, labels ...string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: p.namespace, Subsystem: subsystem, Name: name, Help: help, ConstLabels: p.constLabels,
+		}, labels)
+	}
+	histogram := func(name, help string, buckets []float64, labels ...string) *prometheus.HistogramVec {
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: p.namespace, Subsystem: subsystem, Name: name, Help: help, ConstLabels: p.constLabels,
+			Buckets: buckets,
+		}, labels)
+	}
+	p.active = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: p.namespace, Subsystem: subsystem, Name: "active_sessions", Help: "Number of tunnels currently forwarding.",
+		ConstLabels: p.constLabels,
+	}, []string{"protocol"})
+	p.sessions = counter("sessions_total", "Number of tunnels which started forwarding.", "protocol")
+	p.closed = counter("sessions_closed_total", "Number of tunnels which stopped forwarding, by close status.", "protocol", "status")
+	p.handshakes = histogram("handshake_duration_seconds", "Duration of the handshake with guacd.",
+		prometheus.DefBuckets, "protocol", "result")
+	p.durations = histogram("session_duration_seconds", "Duration of forwarding.", p.durationBuckets, "protocol")
+	p.bytes = counter("bytes_total", "Number of bytes forwarded.", "protocol", "direction")
+	p.instructions = counter("instructions_total", "Number of instructions forwarded, by opcode.", "protocol", "direction", "opcode")
+	p.roundTrips = histogram("round_trip_seconds", "Time between sending a sync to the client and its answer.",
+		prometheus.ExponentialBuckets(0.005, 2, 10), "protocol")
+	return p
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/riete/go-guac/protocol"
+	"github.com/riete/go-guac/tunnel"
+)
+
+func TestPrometheus(t *testing.T) {
+	p := NewPrometheus(WithNamespace("test"))
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(p); err != nil {
+		t.Fatal(err)
+	}
+	tun := tunnel.NewTunnelWithTransport(nil, nil)
+	p.Handshake(tun, 10*time.Millisecond, nil)
+	p.Handshake(tun, 10*time.Millisecond, errors.New("refused"))
+	p.SessionStarted(tun)
+	p.Instruction(tun, tunnel.GuacdToClient, protocol.OpSync, 12)
+	p.Instruction(tun, tunnel.GuacdToClient, "custom", 8)
+	p.RoundTrip(tun, 20*time.Millisecond)
+	p.SessionEnded(tun, time.Minute, protocol.SessionTimeout)
+
+	expected := `
+# HELP test_guac_bytes_total Number of bytes forwarded.
+# TYPE test_guac_bytes_total counter
+test_guac_bytes_total{direction="guacd_to_client",protocol=""} 20
+# HELP test_guac_instructions_total Number of instructions forwarded, by opcode.
+# TYPE test_guac_instructions_total counter
+test_guac_instructions_total{direction="guacd_to_client",opcode="other",protocol=""} 1
+test_guac_instructions_total{direction="guacd_to_client",opcode="sync",protocol=""} 1
+# HELP test_guac_active_sessions Number of tunnels currently forwarding.
+# TYPE test_guac_active_sessions gauge
+test_guac_active_sessions{protocol=""} 0
+# HELP test_guac_sessions_total Number of tunnels which started forwarding.
+# TYPE test_guac_sessions_total counter
+test_guac_sessions_total{protocol=""} 1
+# HELP test_guac_sessions_closed_total Number of tunnels which stopped forwarding, by close status.
+# TYPE test_guac_sessions_closed_total counter
+test_guac_sessions_closed_total{protocol="",status="522_SESSION_TIMEOUT"} 1
+`
+	names := []string{
+		"test_guac_bytes_total", "test_guac_instructions_total", "test_guac_active_sessions", "test_guac_sessions_total",
+		"test_guac_sessions_closed_total",
+	}
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), names...); err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.CollectAndCount(p, "test_guac_handshake_duration_seconds"); n != 2 {
+		t.Fatalf("got %d handshake results, want 2", n)
+	}
+	histograms := []struct {
+		name string
+		sum  float64
+	}{
+		{"test_guac_session_duration_seconds", 60},
+		{"test_guac_round_trip_seconds", 0.02},
+	}
+	for _, h := range histograms {
+		count, sum := histogram(t, registry, h.name)
+		if count != 1 || sum != h.sum {
+			t.Fatalf("%s: got count %d sum %v, want count 1 sum %v", h.name, count, sum, h.sum)
+		}
+	}
+}
+
+// histogram returns the sample count and sum of the only series of a histogram
+func histogram(t *testing.T, registry *prometheus.Registry, name string) (uint64, float64) {
+	t.Helper()
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == name && len(family.GetMetric()) == 1 {
+			h := family.GetMetric()[0].GetHistogram()
+			return h.GetSampleCount(), h.GetSampleSum()
+		}
+	}
+	t.Fatalf("histogram %s not found", name)
+	return 0, 0
+}
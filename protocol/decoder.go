@@ -0,0 +1,191 @@
+package protocol
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxLengthDigits limits the length prefix of an element, the same limit guacd and guacamole-client apply
+const maxLengthDigits = 5
+
+var (
+	// ErrInvalidLength is returned when an element length prefix is empty, not decimal or too long
+	ErrInvalidLength = errors.New("invalid element length")
+
+	// ErrInvalidTerminator is returned when an element is not followed by "," or ";"
+	ErrInvalidTerminator = errors.New("invalid element terminator")
+
+	// ErrInstructionTooLong is returned when an instruction exceeds the configured maximum length
+	ErrInstructionTooLong = errors.New("instruction too long")
+)
+
+// DecodeError reports malformed instruction data together with the byte offset within the instruction
+type DecodeError struct {
+	Offset int
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode instruction error at offset %d: %s", e.Offset, e.Err.Error())
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+type DecoderOption func(*Decoder)
+
+// WithMaxInstructionLength limits the number of bytes of a single instruction, 0 means no limit
+func WithMaxInstructionLength(n int) DecoderOption {
+	return func(d *Decoder) {
+		d.maxLength = n
+	}
+}
+
+// Decoder reads instructions from a stream element by element.
+// Element values are consumed by their declared length in Unicode characters,
+// so "," and ";" inside a value never split an instruction
+type Decoder struct {
+	r         io.ByteReader
+	buf       []byte
+	ends      []int
+	maxLength int
+}
+
+// Decode reads the next instruction. It returns io.EOF only if the stream ends between two instructions,
+// io.ErrUnexpectedEOF wrapped in a DecodeError if it ends in the middle of one
+func (d *Decoder) Decode() (Instruction, error) {
+	b, err := d.next()
+	if err != nil {
+		return "", err
+	}
+	return Instruction(b), nil
+}
+
+// Values returns the opcode followed by the argument values of the most recently decoded instruction
+func (d *Decoder) Values() []string {
+	return splitValues(string(d.buf), d.ends)
+}
+
+// splitValues cuts the values out of a raw instruction given the offsets of its element terminators
+func splitValues(s string, ends []int) []string {
+	values := make([]string, 0, len(ends))
+	start := 0
+	for _, end := range ends {
+		element := s[start:end]
+		values = append(values, element[strings.IndexByte(element, '.')+1:])
+		start = end + 1
+	}
+	return values
+}
+
+// Buffered returns the number of bytes that have been read from the underlying reader but not yet decoded
+func (d *Decoder) Buffered() int {
+	if br, ok := d.r.(*bufio.Reader); ok {
+		return br.Buffered()
+	}
+	return 0
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	if d.maxLength > 0 && len(d.buf) >= d.maxLength {
+		return 0, &DecodeError{Offset: len(d.buf), Err: ErrInstructionTooLong}
+	}
+	c, err := d.r.ReadByte()
+	if err == io.EOF {
+		if len(d.buf) == 0 {
+			return 0, io.EOF
+		}
+		return 0, &DecodeError{Offset: len(d.buf), Err: io.ErrUnexpectedEOF}
+	}
+	if err != nil {
+		return 0, err
+	}
+	d.buf = append(d.buf, c)
+	return c, nil
+}
+
+// next reads a complete instruction into d.buf, recording the offset of each element terminator in d.ends.
+// The returned slice is only valid until the next call
+func (d *Decoder) next() ([]byte, error) {
+	d.buf = d.buf[:0]
+	d.ends = d.ends[:0]
+	for {
+		terminator, err := d.nextElement()
+		if err != nil {
+			return nil, err
+		}
+		if terminator == ';' {
+			return d.buf, nil
+		}
+	}
+}
+
+// nextElement appends the next element and its terminator to d.buf, recording the offset of the terminator in d.ends
+func (d *Decoder) nextElement() (byte, error) {
+	// length prefix
+	length, digits := 0, 0
+	for {
+		c, err := d.readByte()
+		if err != nil {
+			return 0, err
+		}
+		if c == '.' && digits > 0 {
+			break
+		}
+		if c < '0' || c > '9' || digits == maxLengthDigits {
+			return 0, &DecodeError{Offset: len(d.buf) - 1, Err: ErrInvalidLength}
+		}
+		length = length*10 + int(c-'0')
+		digits++
+	}
+
+	// value, counted in runes: every byte that is not a UTF-8 continuation byte starts a new rune,
+	// the rune following the value is the terminator
+	for runes := 0; ; {
+		c, err := d.readByte()
+		if err != nil {
+			return 0, err
+		}
+		if c&0xC0 == 0x80 {
+			continue
+		}
+		if runes < length {
+			runes++
+			continue
+		}
+		d.ends = append(d.ends, len(d.buf)-1)
+		if c != ',' && c != ';' {
+			return 0, &DecodeError{Offset: len(d.buf) - 1, Err: ErrInvalidTerminator}
+		}
+		return c, nil
+	}
+}
+
+// NewDecoder returns a Decoder reading from r. If r is not an io.ByteReader it is wrapped in a bufio.Reader
+func NewDecoder(r io.Reader, opts ...DecoderOption) *Decoder {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	d := &Decoder{r: br}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Values parses the instruction and returns the opcode followed by the argument values
+func (i Instruction) Values() ([]string, error) {
+	d := NewDecoder(strings.NewReader(string(i)))
+	if _, err := d.next(); err != nil {
+		if err == io.EOF {
+			err = &DecodeError{Err: io.ErrUnexpectedEOF}
+		}
+		return nil, err
+	}
+	return splitValues(string(i), d.ends), nil
+}
@@ -0,0 +1,71 @@
+package protocol
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDecoder(t *testing.T) {
+	first := NewInstruction("select", "aa,,a", "b;b;b", "中文,;")
+	second := NewInstruction("nop")
+	d := NewDecoder(strings.NewReader(string(first + second)))
+
+	instr, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instr != first {
+		t.Fatalf("got %q, want %q", instr, first)
+	}
+	values := d.Values()
+	want := []string{"select", "aa,,a", "b;b;b", "中文,;"}
+	if strings.Join(values, "|") != strings.Join(want, "|") {
+		t.Fatalf("got values %q, want %q", values, want)
+	}
+
+	if instr, err = d.Decode(); err != nil || instr != second {
+		t.Fatalf("got %q %v, want %q", instr, err, second)
+	}
+	if _, err = d.Decode(); err != io.EOF {
+		t.Fatalf("got %v, want io.EOF", err)
+	}
+}
+
+func TestDecoderMalformed(t *testing.T) {
+	cases := map[string]error{
+		"6select;":       ErrInvalidLength,
+		".select;":       ErrInvalidLength,
+		"123456.a;":      ErrInvalidLength,
+		"6.select.3.rdp": ErrInvalidTerminator,
+		"6.select,3.rd":  io.ErrUnexpectedEOF,
+		"4.sync,3.12":    io.ErrUnexpectedEOF,
+	}
+	for data, want := range cases {
+		_, err := NewDecoder(strings.NewReader(data)).Decode()
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) || !errors.Is(err, want) {
+			t.Errorf("%q: got %v, want %v", data, err, want)
+		}
+	}
+
+	_, err := NewDecoder(strings.NewReader(string(NewInstruction("blob", "0", strings.Repeat("A", 64)))),
+		WithMaxInstructionLength(32)).Decode()
+	if !errors.Is(err, ErrInstructionTooLong) {
+		t.Errorf("got %v, want %v", err, ErrInstructionTooLong)
+	}
+}
+
+func TestInstructionValues(t *testing.T) {
+	values, err := NewInstruction("error", "bad, very bad;", "769").Values()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 3 || values[1] != "bad, very bad;" || values[2] != "769" {
+		t.Fatalf("unexpected values %q", values)
+	}
+	if _, err = Instruction("5.error,3.ba").Values(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
@@ -0,0 +1,780 @@
+package protocol
+
+// Drawing instructions
+// https://guacamole.apache.org/doc/gug/protocol-reference.html#drawing-instructions
+
+// Arc adds the specified arc to the current path of the layer
+type Arc struct {
+	Layer    int
+	X        int
+	Y        int
+	Radius   int
+	Start    float64
+	End      float64
+	Negative bool
+}
+
+func (a Arc) Opcode() string {
+	return OpArc
+}
+
+func (a Arc) Marshal() Instruction {
+	return NewInstruction(OpArc, itoa(a.Layer), itoa(a.X), itoa(a.Y), itoa(a.Radius), ftoa(a.Start), ftoa(a.End), btoa(a.Negative))
+}
+
+func (a *Arc) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpArc, 7)
+	if err != nil {
+		return err
+	}
+	a.Layer = ar.int()
+	a.X = ar.int()
+	a.Y = ar.int()
+	a.Radius = ar.int()
+	a.Start = ar.float()
+	a.End = ar.float()
+	a.Negative = ar.bool()
+	return ar.err
+}
+
+// Cfill fills the current path of the layer with the specified color
+type Cfill struct {
+	Mask  int
+	Layer int
+	Red   int
+	Green int
+	Blue  int
+	Alpha int
+}
+
+func (c Cfill) Opcode() string {
+	return OpCfill
+}
+
+func (c Cfill) Marshal() Instruction {
+	return NewInstruction(OpCfill, itoa(c.Mask), itoa(c.Layer), itoa(c.Red), itoa(c.Green), itoa(c.Blue), itoa(c.Alpha))
+}
+
+func (c *Cfill) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpCfill, 6)
+	if err != nil {
+		return err
+	}
+	c.Mask = ar.int()
+	c.Layer = ar.int()
+	c.Red = ar.int()
+	c.Green = ar.int()
+	c.Blue = ar.int()
+	c.Alpha = ar.int()
+	return ar.err
+}
+
+// Clip closes and applies the current path of the layer as its clipping path
+type Clip struct {
+	Layer int
+}
+
+func (c Clip) Opcode() string {
+	return OpClip
+}
+
+func (c Clip) Marshal() Instruction {
+	return NewInstruction(OpClip, itoa(c.Layer))
+}
+
+func (c *Clip) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpClip, 1)
+	if err != nil {
+		return err
+	}
+	c.Layer = ar.int()
+	return ar.err
+}
+
+// Close closes the current path of the layer
+type Close struct {
+	Layer int
+}
+
+func (c Close) Opcode() string {
+	return OpClose
+}
+
+func (c Close) Marshal() Instruction {
+	return NewInstruction(OpClose, itoa(c.Layer))
+}
+
+func (c *Close) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpClose, 1)
+	if err != nil {
+		return err
+	}
+	c.Layer = ar.int()
+	return ar.err
+}
+
+// Copy copies image data from the source layer to the destination layer
+type Copy struct {
+	SrcLayer  int
+	SrcX      int
+	SrcY      int
+	SrcWidth  int
+	SrcHeight int
+	Mask      int
+	DstLayer  int
+	DstX      int
+	DstY      int
+}
+
+func (c Copy) Opcode() string {
+	return OpCopy
+}
+
+func (c Copy) Marshal() Instruction {
+	return NewInstruction(OpCopy, itoa(c.SrcLayer), itoa(c.SrcX), itoa(c.SrcY), itoa(c.SrcWidth), itoa(c.SrcHeight), itoa(c.Mask), itoa(c.DstLayer), itoa(c.DstX), itoa(c.DstY))
+}
+
+func (c *Copy) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpCopy, 9)
+	if err != nil {
+		return err
+	}
+	c.SrcLayer = ar.int()
+	c.SrcX = ar.int()
+	c.SrcY = ar.int()
+	c.SrcWidth = ar.int()
+	c.SrcHeight = ar.int()
+	c.Mask = ar.int()
+	c.DstLayer = ar.int()
+	c.DstX = ar.int()
+	c.DstY = ar.int()
+	return ar.err
+}
+
+// Cstroke strokes the current path of the layer with the specified color
+type Cstroke struct {
+	Mask      int
+	Layer     int
+	Cap       int
+	Join      int
+	Thickness int
+	Red       int
+	Green     int
+	Blue      int
+	Alpha     int
+}
+
+func (c Cstroke) Opcode() string {
+	return OpCstroke
+}
+
+func (c Cstroke) Marshal() Instruction {
+	return NewInstruction(OpCstroke, itoa(c.Mask), itoa(c.Layer), itoa(c.Cap), itoa(c.Join), itoa(c.Thickness), itoa(c.Red), itoa(c.Green), itoa(c.Blue), itoa(c.Alpha))
+}
+
+func (c *Cstroke) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpCstroke, 9)
+	if err != nil {
+		return err
+	}
+	c.Mask = ar.int()
+	c.Layer = ar.int()
+	c.Cap = ar.int()
+	c.Join = ar.int()
+	c.Thickness = ar.int()
+	c.Red = ar.int()
+	c.Green = ar.int()
+	c.Blue = ar.int()
+	c.Alpha = ar.int()
+	return ar.err
+}
+
+// Cursor sets the client mouse cursor to the specified rectangle of the source layer
+type Cursor struct {
+	X         int
+	Y         int
+	SrcLayer  int
+	SrcX      int
+	SrcY      int
+	SrcWidth  int
+	SrcHeight int
+}
+
+func (c Cursor) Opcode() string {
+	return OpCursor
+}
+
+func (c Cursor) Marshal() Instruction {
+	return NewInstruction(OpCursor, itoa(c.X), itoa(c.Y), itoa(c.SrcLayer), itoa(c.SrcX), itoa(c.SrcY), itoa(c.SrcWidth), itoa(c.SrcHeight))
+}
+
+func (c *Cursor) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpCursor, 7)
+	if err != nil {
+		return err
+	}
+	c.X = ar.int()
+	c.Y = ar.int()
+	c.SrcLayer = ar.int()
+	c.SrcX = ar.int()
+	c.SrcY = ar.int()
+	c.SrcWidth = ar.int()
+	c.SrcHeight = ar.int()
+	return ar.err
+}
+
+// Curve adds the specified cubic bezier curve to the current path of the layer
+type Curve struct {
+	Layer int
+	Cp1X  int
+	Cp1Y  int
+	Cp2X  int
+	Cp2Y  int
+	X     int
+	Y     int
+}
+
+func (c Curve) Opcode() string {
+	return OpCurve
+}
+
+func (c Curve) Marshal() Instruction {
+	return NewInstruction(OpCurve, itoa(c.Layer), itoa(c.Cp1X), itoa(c.Cp1Y), itoa(c.Cp2X), itoa(c.Cp2Y), itoa(c.X), itoa(c.Y))
+}
+
+func (c *Curve) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpCurve, 7)
+	if err != nil {
+		return err
+	}
+	c.Layer = ar.int()
+	c.Cp1X = ar.int()
+	c.Cp1Y = ar.int()
+	c.Cp2X = ar.int()
+	c.Cp2Y = ar.int()
+	c.X = ar.int()
+	c.Y = ar.int()
+	return ar.err
+}
+
+// Dispose removes the layer or buffer
+type Dispose struct {
+	Layer int
+}
+
+func (d Dispose) Opcode() string {
+	return OpDispose
+}
+
+func (d Dispose) Marshal() Instruction {
+	return NewInstruction(OpDispose, itoa(d.Layer))
+}
+
+func (d *Dispose) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpDispose, 1)
+	if err != nil {
+		return err
+	}
+	d.Layer = ar.int()
+	return ar.err
+}
+
+// Distort sets the transformation matrix of the layer relative to its parent
+type Distort struct {
+	Layer int
+	A     float64
+	B     float64
+	C     float64
+	D     float64
+	E     float64
+	F     float64
+}
+
+func (d Distort) Opcode() string {
+	return OpDistort
+}
+
+func (d Distort) Marshal() Instruction {
+	return NewInstruction(OpDistort, itoa(d.Layer), ftoa(d.A), ftoa(d.B), ftoa(d.C), ftoa(d.D), ftoa(d.E), ftoa(d.F))
+}
+
+func (d *Distort) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpDistort, 7)
+	if err != nil {
+		return err
+	}
+	d.Layer = ar.int()
+	d.A = ar.float()
+	d.B = ar.float()
+	d.C = ar.float()
+	d.D = ar.float()
+	d.E = ar.float()
+	d.F = ar.float()
+	return ar.err
+}
+
+// Identity resets the transformation matrix of the layer to the identity matrix
+type Identity struct {
+	Layer int
+}
+
+func (i Identity) Opcode() string {
+	return OpIdentity
+}
+
+func (i Identity) Marshal() Instruction {
+	return NewInstruction(OpIdentity, itoa(i.Layer))
+}
+
+func (i *Identity) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpIdentity, 1)
+	if err != nil {
+		return err
+	}
+	i.Layer = ar.int()
+	return ar.err
+}
+
+// Jpeg draws base64 encoded JPEG data to the layer. Deprecated: replaced by img
+type Jpeg struct {
+	Mask  int
+	Layer int
+	X     int
+	Y     int
+	Data  string
+}
+
+func (j Jpeg) Opcode() string {
+	return OpJpeg
+}
+
+func (j Jpeg) Marshal() Instruction {
+	return NewInstruction(OpJpeg, itoa(j.Mask), itoa(j.Layer), itoa(j.X), itoa(j.Y), j.Data)
+}
+
+func (j *Jpeg) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpJpeg, 5)
+	if err != nil {
+		return err
+	}
+	j.Mask = ar.int()
+	j.Layer = ar.int()
+	j.X = ar.int()
+	j.Y = ar.int()
+	j.Data = ar.string()
+	return ar.err
+}
+
+// Lfill fills the current path of the layer with the contents of the source layer as a pattern
+type Lfill struct {
+	Mask     int
+	Layer    int
+	SrcLayer int
+}
+
+func (l Lfill) Opcode() string {
+	return OpLfill
+}
+
+func (l Lfill) Marshal() Instruction {
+	return NewInstruction(OpLfill, itoa(l.Mask), itoa(l.Layer), itoa(l.SrcLayer))
+}
+
+func (l *Lfill) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpLfill, 3)
+	if err != nil {
+		return err
+	}
+	l.Mask = ar.int()
+	l.Layer = ar.int()
+	l.SrcLayer = ar.int()
+	return ar.err
+}
+
+// Line adds a straight line to the current path of the layer
+type Line struct {
+	Layer int
+	X     int
+	Y     int
+}
+
+func (l Line) Opcode() string {
+	return OpLine
+}
+
+func (l Line) Marshal() Instruction {
+	return NewInstruction(OpLine, itoa(l.Layer), itoa(l.X), itoa(l.Y))
+}
+
+func (l *Line) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpLine, 3)
+	if err != nil {
+		return err
+	}
+	l.Layer = ar.int()
+	l.X = ar.int()
+	l.Y = ar.int()
+	return ar.err
+}
+
+// Lstroke strokes the current path of the layer with the contents of the source layer as a pattern
+type Lstroke struct {
+	Mask      int
+	Layer     int
+	Cap       int
+	Join      int
+	Thickness int
+	SrcLayer  int
+}
+
+func (l Lstroke) Opcode() string {
+	return OpLstroke
+}
+
+func (l Lstroke) Marshal() Instruction {
+	return NewInstruction(OpLstroke, itoa(l.Mask), itoa(l.Layer), itoa(l.Cap), itoa(l.Join), itoa(l.Thickness), itoa(l.SrcLayer))
+}
+
+func (l *Lstroke) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpLstroke, 6)
+	if err != nil {
+		return err
+	}
+	l.Mask = ar.int()
+	l.Layer = ar.int()
+	l.Cap = ar.int()
+	l.Join = ar.int()
+	l.Thickness = ar.int()
+	l.SrcLayer = ar.int()
+	return ar.err
+}
+
+// Move reparents the layer and moves it to the specified position
+type Move struct {
+	Layer  int
+	Parent int
+	X      int
+	Y      int
+	Z      int
+}
+
+func (m Move) Opcode() string {
+	return OpMove
+}
+
+func (m Move) Marshal() Instruction {
+	return NewInstruction(OpMove, itoa(m.Layer), itoa(m.Parent), itoa(m.X), itoa(m.Y), itoa(m.Z))
+}
+
+func (m *Move) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpMove, 5)
+	if err != nil {
+		return err
+	}
+	m.Layer = ar.int()
+	m.Parent = ar.int()
+	m.X = ar.int()
+	m.Y = ar.int()
+	m.Z = ar.int()
+	return ar.err
+}
+
+// Png draws base64 encoded PNG data to the layer. Deprecated: replaced by img
+type Png struct {
+	Mask  int
+	Layer int
+	X     int
+	Y     int
+	Data  string
+}
+
+func (p Png) Opcode() string {
+	return OpPng
+}
+
+func (p Png) Marshal() Instruction {
+	return NewInstruction(OpPng, itoa(p.Mask), itoa(p.Layer), itoa(p.X), itoa(p.Y), p.Data)
+}
+
+func (p *Png) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpPng, 5)
+	if err != nil {
+		return err
+	}
+	p.Mask = ar.int()
+	p.Layer = ar.int()
+	p.X = ar.int()
+	p.Y = ar.int()
+	p.Data = ar.string()
+	return ar.err
+}
+
+// Pop restores the previously pushed layer state
+type Pop struct {
+	Layer int
+}
+
+func (p Pop) Opcode() string {
+	return OpPop
+}
+
+func (p Pop) Marshal() Instruction {
+	return NewInstruction(OpPop, itoa(p.Layer))
+}
+
+func (p *Pop) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpPop, 1)
+	if err != nil {
+		return err
+	}
+	p.Layer = ar.int()
+	return ar.err
+}
+
+// Push saves the current layer state
+type Push struct {
+	Layer int
+}
+
+func (p Push) Opcode() string {
+	return OpPush
+}
+
+func (p Push) Marshal() Instruction {
+	return NewInstruction(OpPush, itoa(p.Layer))
+}
+
+func (p *Push) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpPush, 1)
+	if err != nil {
+		return err
+	}
+	p.Layer = ar.int()
+	return ar.err
+}
+
+// Rect adds the specified rectangle to the current path of the layer
+type Rect struct {
+	Layer  int
+	X      int
+	Y      int
+	Width  int
+	Height int
+}
+
+func (r Rect) Opcode() string {
+	return OpRect
+}
+
+func (r Rect) Marshal() Instruction {
+	return NewInstruction(OpRect, itoa(r.Layer), itoa(r.X), itoa(r.Y), itoa(r.Width), itoa(r.Height))
+}
+
+func (r *Rect) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpRect, 5)
+	if err != nil {
+		return err
+	}
+	r.Layer = ar.int()
+	r.X = ar.int()
+	r.Y = ar.int()
+	r.Width = ar.int()
+	r.Height = ar.int()
+	return ar.err
+}
+
+// Reset resets the layer state, clearing the current path, clipping path and transform
+type Reset struct {
+	Layer int
+}
+
+func (r Reset) Opcode() string {
+	return OpReset
+}
+
+func (r Reset) Marshal() Instruction {
+	return NewInstruction(OpReset, itoa(r.Layer))
+}
+
+func (r *Reset) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpReset, 1)
+	if err != nil {
+		return err
+	}
+	r.Layer = ar.int()
+	return ar.err
+}
+
+// Set assigns a layer property such as miter-limit
+type Set struct {
+	Layer    int
+	Property string
+	Value    string
+}
+
+func (s Set) Opcode() string {
+	return OpSet
+}
+
+func (s Set) Marshal() Instruction {
+	return NewInstruction(OpSet, itoa(s.Layer), s.Property, s.Value)
+}
+
+func (s *Set) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpSet, 3)
+	if err != nil {
+		return err
+	}
+	s.Layer = ar.int()
+	s.Property = ar.string()
+	s.Value = ar.string()
+	return ar.err
+}
+
+// Shade sets the opacity of the layer
+type Shade struct {
+	Layer   int
+	Opacity int
+}
+
+func (s Shade) Opcode() string {
+	return OpShade
+}
+
+func (s Shade) Marshal() Instruction {
+	return NewInstruction(OpShade, itoa(s.Layer), itoa(s.Opacity))
+}
+
+func (s *Shade) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpShade, 2)
+	if err != nil {
+		return err
+	}
+	s.Layer = ar.int()
+	s.Opacity = ar.int()
+	return ar.err
+}
+
+// LayerSize is the server side size instruction which resizes a layer
+type LayerSize struct {
+	Layer  int
+	Width  int
+	Height int
+}
+
+func (l LayerSize) Opcode() string {
+	return OpSize
+}
+
+func (l LayerSize) Marshal() Instruction {
+	return NewInstruction(OpSize, itoa(l.Layer), itoa(l.Width), itoa(l.Height))
+}
+
+func (l *LayerSize) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpSize, 3)
+	if err != nil {
+		return err
+	}
+	l.Layer = ar.int()
+	l.Width = ar.int()
+	l.Height = ar.int()
+	return ar.err
+}
+
+// Start begins a new subpath of the current path of the layer
+type Start struct {
+	Layer int
+	X     int
+	Y     int
+}
+
+func (s Start) Opcode() string {
+	return OpStart
+}
+
+func (s Start) Marshal() Instruction {
+	return NewInstruction(OpStart, itoa(s.Layer), itoa(s.X), itoa(s.Y))
+}
+
+func (s *Start) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpStart, 3)
+	if err != nil {
+		return err
+	}
+	s.Layer = ar.int()
+	s.X = ar.int()
+	s.Y = ar.int()
+	return ar.err
+}
+
+// Transfer transfers image data from the source layer to the destination layer using a transfer function
+type Transfer struct {
+	SrcLayer  int
+	SrcX      int
+	SrcY      int
+	SrcWidth  int
+	SrcHeight int
+	Function  int
+	DstLayer  int
+	DstX      int
+	DstY      int
+}
+
+func (t Transfer) Opcode() string {
+	return OpTransfer
+}
+
+func (t Transfer) Marshal() Instruction {
+	return NewInstruction(OpTransfer, itoa(t.SrcLayer), itoa(t.SrcX), itoa(t.SrcY), itoa(t.SrcWidth), itoa(t.SrcHeight), itoa(t.Function), itoa(t.DstLayer), itoa(t.DstX), itoa(t.DstY))
+}
+
+func (t *Transfer) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpTransfer, 9)
+	if err != nil {
+		return err
+	}
+	t.SrcLayer = ar.int()
+	t.SrcX = ar.int()
+	t.SrcY = ar.int()
+	t.SrcWidth = ar.int()
+	t.SrcHeight = ar.int()
+	t.Function = ar.int()
+	t.DstLayer = ar.int()
+	t.DstX = ar.int()
+	t.DstY = ar.int()
+	return ar.err
+}
+
+// Transform applies the specified transformation matrix to the layer
+type Transform struct {
+	Layer int
+	A     float64
+	B     float64
+	C     float64
+	D     float64
+	E     float64
+	F     float64
+}
+
+func (t Transform) Opcode() string {
+	return OpTransform
+}
+
+func (t Transform) Marshal() Instruction {
+	return NewInstruction(OpTransform, itoa(t.Layer), ftoa(t.A), ftoa(t.B), ftoa(t.C), ftoa(t.D), ftoa(t.E), ftoa(t.F))
+}
+
+func (t *Transform) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpTransform, 7)
+	if err != nil {
+		return err
+	}
+	t.Layer = ar.int()
+	t.A = ar.float()
+	t.B = ar.float()
+	t.C = ar.float()
+	t.D = ar.float()
+	t.E = ar.float()
+	t.F = ar.float()
+	return ar.err
+}
@@ -0,0 +1,92 @@
+package protocol
+
+import (
+	"io"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// AppendElement appends s encoded as LENGTH.VALUE to dst
+func AppendElement(dst []byte, s string) []byte {
+	dst = strconv.AppendInt(dst, int64(utf8.RuneCountInString(s)), 10)
+	dst = append(dst, '.')
+	return append(dst, s...)
+}
+
+// AppendIntElement appends the decimal representation of n encoded as LENGTH.VALUE to dst
+func AppendIntElement(dst []byte, n int64) []byte {
+	var scratch [20]byte
+	digits := strconv.AppendInt(scratch[:0], n, 10)
+	dst = strconv.AppendInt(dst, int64(len(digits)), 10)
+	dst = append(dst, '.')
+	return append(dst, digits...)
+}
+
+// AppendInstruction appends the encoded instruction to dst, it is the allocation free counterpart of NewInstruction
+func AppendInstruction(dst []byte, opcode string, args ...string) []byte {
+	dst = AppendElement(dst, opcode)
+	for _, arg := range args {
+		dst = append(dst, ',')
+		dst = AppendElement(dst, arg)
+	}
+	return append(dst, ';')
+}
+
+// AppendIntInstruction appends an instruction whose arguments are all integers, e.g. mouse, key or sync
+func AppendIntInstruction(dst []byte, opcode string, args ...int64) []byte {
+	dst = AppendElement(dst, opcode)
+	for _, arg := range args {
+		dst = append(dst, ',')
+		dst = AppendIntElement(dst, arg)
+	}
+	return append(dst, ';')
+}
+
+var scratchPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 256)
+		return &b
+	},
+}
+
+// WriteInstruction encodes the instruction into pooled scratch space and writes it to w with a single Write call
+func WriteInstruction(w io.Writer, opcode string, args ...string) error {
+	bp := scratchPool.Get().(*[]byte)
+	b := AppendInstruction((*bp)[:0], opcode, args...)
+	_, err := w.Write(b)
+	*bp = b
+	scratchPool.Put(bp)
+	return err
+}
+
+// Encoder writes instructions to an io.Writer, reusing its scratch buffer between calls.
+// An Encoder is not safe for concurrent use
+type Encoder struct {
+	w   io.Writer
+	buf []byte
+}
+
+// Encode writes the instruction built from opcode and args
+func (e *Encoder) Encode(opcode string, args ...string) error {
+	e.buf = AppendInstruction(e.buf[:0], opcode, args...)
+	_, err := e.w.Write(e.buf)
+	return err
+}
+
+// EncodeInts writes an instruction whose arguments are all integers without converting them to strings first
+func (e *Encoder) EncodeInts(opcode string, args ...int64) error {
+	e.buf = AppendIntInstruction(e.buf[:0], opcode, args...)
+	_, err := e.w.Write(e.buf)
+	return err
+}
+
+// EncodeInstruction writes an already encoded instruction
+func (e *Encoder) EncodeInstruction(instr Instruction) error {
+	_, err := e.w.Write(instr.Byte())
+	return err
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, buf: make([]byte, 0, 256)}
+}
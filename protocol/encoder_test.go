@@ -0,0 +1,63 @@
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"testing"
+)
+
+func TestEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	if err := e.Encode("select", "aa,,a", "中文;"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.EncodeInts("mouse", 100, -20, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteInstruction(&buf, "nop"); err != nil {
+		t.Fatal(err)
+	}
+	want := NewInstruction("select", "aa,,a", "中文;") + NewInstruction("mouse", "100", "-20", "1") + Nop
+	if got := buf.String(); got != string(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func BenchmarkNewInstruction(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = io.Discard.Write(NewInstruction("key", "65307", "1").Byte())
+	}
+}
+
+func BenchmarkNewInstructionInts(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = io.Discard.Write(NewInstruction("mouse", strconv.Itoa(i), "200", "1").Byte())
+	}
+}
+
+func BenchmarkEncoder(b *testing.B) {
+	b.ReportAllocs()
+	e := NewEncoder(io.Discard)
+	for i := 0; i < b.N; i++ {
+		_ = e.Encode("key", "65307", "1")
+	}
+}
+
+func BenchmarkEncoderInts(b *testing.B) {
+	b.ReportAllocs()
+	e := NewEncoder(io.Discard)
+	for i := 0; i < b.N; i++ {
+		_ = e.EncodeInts("mouse", int64(i), 200, 1)
+	}
+}
+
+func BenchmarkWriteInstruction(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = WriteInstruction(io.Discard, "sync", "1700000000000", "1")
+	}
+}
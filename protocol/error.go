@@ -0,0 +1,38 @@
+package protocol
+
+import (
+	"strconv"
+)
+
+// Error notifies that the connection is closing due to an error.
+// It is also the error returned for error instructions received from guacd, use errors.As to retrieve the Status
+type Error struct {
+	Message string
+	Status  StatusCode
+}
+
+func (e Error) Opcode() string {
+	return OpError
+}
+
+func (e Error) Marshal() Instruction {
+	return NewInstruction(OpError, e.Message, strconv.FormatInt(int64(e.Status), 10))
+}
+
+func (e *Error) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpError, 2)
+	if err != nil {
+		return err
+	}
+	e.Message = ar.string()
+	e.Status = StatusCode(ar.int64())
+	return ar.err
+}
+
+func (e *Error) Error() string {
+	return "server error: " + e.Status.String() + " " + e.Message
+}
+
+func NewError(status StatusCode, message string) *Error {
+	return &Error{Message: message, Status: status}
+}
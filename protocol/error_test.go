@@ -0,0 +1,46 @@
+package protocol
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestInstructionError(t *testing.T) {
+	err := NewInstruction("error", "Permission denied", "769").Error()
+	var guacErr *Error
+	if !errors.As(err, &guacErr) {
+		t.Fatalf("got %T, want *Error", err)
+	}
+	if guacErr.Status != ClientUnauthorized || guacErr.Message != "Permission denied" {
+		t.Fatalf("unexpected error %+v", guacErr)
+	}
+	if !guacErr.Status.ShouldPromptCredentials() || !guacErr.Status.IsClientError() {
+		t.Errorf("%s should be a client error prompting for credentials", guacErr.Status)
+	}
+
+	if err = NewInstruction("error", "broken").Error(); !errors.As(err, &guacErr) || guacErr.Status != ServerError {
+		t.Errorf("malformed error instruction should map to %s, got %v", ServerError, err)
+	}
+	if err = NewInstruction("nop").Error(); err != nil {
+		t.Errorf("got %v, want nil", err)
+	}
+}
+
+func TestStatusCodeClassification(t *testing.T) {
+	if !UpstreamNotFound.IsUpstreamError() || UpstreamNotFound.IsServerError() || !UpstreamNotFound.IsRetryable() {
+		t.Errorf("%s should be a retryable upstream error", UpstreamNotFound)
+	}
+	if !ServerBusy.IsServerError() || ServerBusy.IsClientError() {
+		t.Errorf("%s should be a server error", ServerBusy)
+	}
+	if ClientForbidden.IsRetryable() || ClientForbidden.ShouldPromptCredentials() {
+		t.Errorf("%s should not be retryable", ClientForbidden)
+	}
+	if UpstreamTimeout.HTTPStatus() != http.StatusGatewayTimeout || UpstreamTimeout.WebSocketCloseCode() != 1011 {
+		t.Errorf("unexpected mapping for %s", UpstreamTimeout)
+	}
+	if ClientUnauthorized.HTTPStatus() != http.StatusForbidden || ClientUnauthorized.WebSocketCloseCode() != 1008 {
+		t.Errorf("unexpected mapping for %s", ClientUnauthorized)
+	}
+}
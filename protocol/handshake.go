@@ -2,6 +2,7 @@ package protocol
 
 import (
 	"strconv"
+	"strings"
 )
 
 const defaultScreenWidth = 1920
@@ -87,6 +88,30 @@ func WithReadOnly() HandshakeOption {
 	}
 }
 
+// WithTimezone sends the user's timezone, e.g. Asia/Shanghai, so that the remote session shows local time.
+// It is only sent if guacd supports protocol version 1.1.0
+func WithTimezone(timezone string) HandshakeOption {
+	return func(c *HandshakeConfig) {
+		c.timezone = timezone
+	}
+}
+
+// WithClientName sends the user's display name, shown in the user list of shared sessions.
+// It is only sent if guacd supports protocol version 1.5.0
+func WithClientName(name string) HandshakeOption {
+	return func(c *HandshakeConfig) {
+		c.name = name
+	}
+}
+
+// WithJoinConnection joins the existing connection with the given ID, as returned by Tunnel.ConnId,
+// instead of creating a new one. Combine with WithReadOnly for a view-only shadow session
+func WithJoinConnection(connId string) HandshakeOption {
+	return func(c *HandshakeConfig) {
+		c.joinConnId = connId
+	}
+}
+
 type HandshakeConfig struct {
 	protocol     string
 	connectArgs  map[string]string
@@ -96,6 +121,10 @@ type HandshakeConfig struct {
 	audioCodecs  []string
 	videoCodecs  []string
 	imageFormats []string
+	timezone     string
+	name         string
+	joinConnId   string
+	validate     bool
 }
 
 func (h *HandshakeConfig) setScreen() {
@@ -104,7 +133,33 @@ func (h *HandshakeConfig) setScreen() {
 	h.connectArgs["dpi"] = strconv.Itoa(h.dpi)
 }
 
+// Apply applies further options to an existing config, e.g. the screen size reported by the browser
+func (h *HandshakeConfig) Apply(opts ...HandshakeOption) {
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.setScreen()
+}
+
+// Protocol returns the protocol selected for a new connection, e.g. rdp
+func (h *HandshakeConfig) Protocol() string {
+	return h.protocol
+}
+
+// ConnectArg returns the value of the connect argument name, e.g. hostname
+func (h *HandshakeConfig) ConnectArg(name string) string {
+	return h.connectArgs[name]
+}
+
+// IsJoin reports whether the config joins an existing connection
+func (h *HandshakeConfig) IsJoin() bool {
+	return h.joinConnId != ""
+}
+
 func (h *HandshakeConfig) SelectInstruction() Instruction {
+	if h.IsJoin() {
+		return NewInstruction("select", h.joinConnId)
+	}
 	return NewInstruction("select", h.protocol)
 }
 
@@ -112,9 +167,15 @@ func (h *HandshakeConfig) SizeInstruction() Instruction {
 	return NewInstruction("size", strconv.Itoa(h.width), strconv.Itoa(h.height), strconv.Itoa(h.dpi))
 }
 
+// ConnectInstruction answers args with the configured values in the requested order,
+// the version element is answered with the negotiated protocol version
 func (h *HandshakeConfig) ConnectInstruction(args []Element) Instruction {
 	var argsValues []string
-	for _, arg := range args {
+	for i, arg := range args {
+		if i == 0 && strings.HasPrefix(arg.Value(), versionPrefix) {
+			argsValues = append(argsValues, NegotiateVersion(args).String())
+			continue
+		}
 		argsValues = append(argsValues, h.connectArgs[arg.Value()])
 	}
 	return NewInstruction("connect", argsValues...)
@@ -132,6 +193,27 @@ func (h *HandshakeConfig) ImageInstruction() Instruction {
 	return NewInstruction("image", h.imageFormats...)
 }
 
+func (h *HandshakeConfig) TimezoneInstruction() Instruction {
+	return NewInstruction("timezone", h.timezone)
+}
+
+func (h *HandshakeConfig) NameInstruction() Instruction {
+	return NewInstruction("name", h.name)
+}
+
+// ClientInstructions returns the instructions sent between args and connect,
+// timezone and name are only included if set and supported by the negotiated version
+func (h *HandshakeConfig) ClientInstructions(version ProtocolVersion) Instruction {
+	instr := h.SizeInstruction() + h.AudioInstruction() + h.VideoInstruction() + h.ImageInstruction()
+	if h.timezone != "" && version.Supports(TimezoneHandshake) {
+		instr += h.TimezoneInstruction()
+	}
+	if h.name != "" && version.Supports(NameHandshake) {
+		instr += h.NameInstruction()
+	}
+	return instr
+}
+
 func NewHandshakeConfig(connectArgs map[string]string, opts ...HandshakeOption) *HandshakeConfig {
 	if connectArgs == nil {
 		connectArgs = make(map[string]string)
@@ -1,7 +1,6 @@
 package protocol
 
 import (
-	"fmt"
 	"strconv"
 	"strings"
 	"unicode/utf8"
@@ -19,6 +18,9 @@ type Element string
 func (e Element) Length() int {
 	s := string(e)
 	idx := strings.Index(s, ".")
+	if idx == -1 {
+		return 0
+	}
 	l, _ := strconv.Atoi(s[:idx])
 	return l
 }
@@ -41,41 +43,66 @@ func NewElement(s string) Element {
 // and all following elements are the arguments for that instruction
 type Instruction string
 
+// elementEnd returns the offset of the terminator of the element starting at start, validating it like the Decoder
+// without allocating
+func elementEnd(s string, start int) (int, bool) {
+	i, length, digits := start, 0, 0
+	for ; i < len(s) && s[i] != '.'; i++ {
+		c := s[i]
+		if c < '0' || c > '9' || digits == maxLengthDigits {
+			return 0, false
+		}
+		length = length*10 + int(c-'0')
+		digits++
+	}
+	if i == len(s) || digits == 0 {
+		return 0, false
+	}
+	// value, counted in runes like the Decoder does
+	for i, runes := i+1, 0; i < len(s); i++ {
+		c := s[i]
+		if c&0xC0 == 0x80 {
+			continue
+		}
+		if runes < length {
+			runes++
+			continue
+		}
+		if c != ',' && c != ';' {
+			return 0, false
+		}
+		return i, true
+	}
+	return 0, false
+}
+
+// Opcode returns the first element, it is empty if the instruction is malformed
 func (i Instruction) Opcode() Element {
 	s := string(i)
-	idx := strings.Index(s, ",")
-	if idx == -1 {
-		return Element(s[:strings.LastIndex(s, ";")])
+	end, ok := elementEnd(s, 0)
+	if !ok {
+		return ""
 	}
-	return Element(s[:idx])
+	return Element(s[:end])
 }
 
+// Args returns the elements following the opcode, none if the instruction is malformed. Use Values to get the error
 func (i Instruction) Args() []Element {
 	s := string(i)
-	commaIdx := strings.Index(s, ",")
-	if commaIdx == -1 {
+	end, ok := elementEnd(s, 0)
+	if !ok || s[end] == ';' {
 		return []Element{}
 	}
-	args := s[commaIdx+1:]
 	var elements []Element
-	for {
-		dotIndex := strings.Index(args, ".")
-		length, _ := strconv.Atoi(args[:dotIndex])
-		start := dotIndex + 1
-		runeCount := 0
-		end := start
-		for runeCount < length {
-			_, size := utf8.DecodeRuneInString(args[end:])
-			end += size
-			runeCount++
+	for start := end + 1; ; start = end + 1 {
+		if end, ok = elementEnd(s, start); !ok {
+			return []Element{}
 		}
-		elements = append(elements, Element(args[:end]))
-		if args[end] == ';' {
-			break
+		elements = append(elements, Element(s[start:end]))
+		if s[end] == ';' {
+			return elements
 		}
-		args = args[end+1:]
 	}
-	return elements
 }
 
 func (i Instruction) IsError() bool {
@@ -86,11 +113,19 @@ func (i Instruction) Error() error {
 	if !i.IsError() {
 		return nil
 	}
-	args := i.Args()
-	message := args[0].Value()
-	statusCodeStr := args[1].Value()
-	statusCodeInt, _ := strconv.ParseInt(statusCodeStr, 10, 64)
-	return fmt.Errorf("server error: %s %s", StatusCode(statusCodeInt).String(), message)
+	e := &Error{}
+	if err := e.Unmarshal(i); err != nil {
+		e.Status = ServerError
+		if e.Message == "" {
+			e.Message = "malformed error instruction: " + err.Error()
+		}
+	}
+	return e
+}
+
+// IsInternal reports whether the instruction has the empty opcode of internal tunnel instructions
+func (i Instruction) IsInternal() bool {
+	return strings.HasPrefix(string(i), "0.")
 }
 
 func (i Instruction) Byte() []byte {
@@ -13,3 +13,34 @@ func TestInstruction(t *testing.T) {
 		t.Log(e.Length(), e.Value())
 	}
 }
+
+func TestMalformedInstruction(t *testing.T) {
+	tests := []struct {
+		instr  Instruction
+		opcode Element
+	}{
+		{"", ""},
+		{"sync", ""},
+		{"x.sync;", ""},
+		{"4.sync", ""},
+		{"4.sync,", "4.sync"},
+		{"4.sync,3.12", "4.sync"},
+		{"4.sync,99.1;", "4.sync"},
+	}
+	for _, test := range tests {
+		if opcode := test.instr.Opcode(); opcode != test.opcode {
+			t.Errorf("%q: got opcode %q, want %q", test.instr, opcode, test.opcode)
+		}
+		if args := test.instr.Args(); len(args) != 0 {
+			t.Errorf("%q: got args %q, want none", test.instr, args)
+		}
+	}
+}
+
+func BenchmarkOpcode(b *testing.B) {
+	instr := Mouse{X: 100, Y: 200, Mask: 1}.Marshal()
+	b.ReportAllocs()
+	for b.Loop() {
+		_ = instr.Opcode()
+	}
+}
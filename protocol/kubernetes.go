@@ -0,0 +1,32 @@
+package protocol
+
+// KubernetesParameters https://guacamole.apache.org/doc/gug/configuring-guacamole.html#kubernetes
+type KubernetesParameters struct {
+	Hostname    string `guac:"hostname"`
+	Port        int    `guac:"port"`
+	Namespace   string `guac:"namespace"`
+	Pod         string `guac:"pod"`
+	Container   string `guac:"container"`
+	ExecCommand string `guac:"exec-command"`
+	ReadOnly    bool   `guac:"read-only"`
+
+	// Authentication
+	UseSSL     bool   `guac:"use-ssl"`
+	ClientCert string `guac:"client-cert"`
+	ClientKey  string `guac:"client-key"`
+	CACert     string `guac:"ca-cert"`
+	IgnoreCert bool   `guac:"ignore-cert"`
+
+	TerminalParameters
+	TypescriptParameters
+	RecordingParameters
+	ClipboardParameters
+}
+
+func (p KubernetesParameters) Protocol() string {
+	return "kubernetes"
+}
+
+func (p KubernetesParameters) ConnectArgs() map[string]string {
+	return encodeParameters(p)
+}
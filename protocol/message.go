@@ -0,0 +1,139 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// https://guacamole.apache.org/doc/gug/protocol-reference.html
+
+var (
+	// ErrUnexpectedOpcode is returned when unmarshalling an instruction into a message of another opcode
+	ErrUnexpectedOpcode = errors.New("unexpected opcode")
+
+	// ErrMissingArguments is returned when an instruction has fewer arguments than the opcode requires
+	ErrMissingArguments = errors.New("missing arguments")
+
+	// ErrInvalidArgument is returned when an argument cannot be converted to the type of its field
+	ErrInvalidArgument = errors.New("invalid argument")
+)
+
+// Message is the typed form of an Instruction.
+// Marshal builds the Instruction from the fields, Unmarshal fills the fields from an Instruction of the same opcode.
+// Optional trailing arguments are left as zero values when absent.
+// nop and disconnect carry no arguments, use the global Nop and Disconnect instructions for them
+type Message interface {
+	Opcode() string
+	Marshal() Instruction
+	Unmarshal(instr Instruction) error
+}
+
+// argReader reads instruction arguments in order, remembering the first conversion error
+type argReader struct {
+	opcode string
+	args   []string
+	pos    int
+	err    error
+}
+
+func newArgReader(instr Instruction, opcode string, required int) (*argReader, error) {
+	values, err := instr.Values()
+	if err != nil {
+		return nil, err
+	}
+	if values[0] != opcode {
+		return nil, fmt.Errorf("%w: got %q, want %q", ErrUnexpectedOpcode, values[0], opcode)
+	}
+	if len(values)-1 < required {
+		return nil, fmt.Errorf("%w: %s requires %d, got %d", ErrMissingArguments, opcode, required, len(values)-1)
+	}
+	return &argReader{opcode: opcode, args: values[1:]}, nil
+}
+
+func (r *argReader) next() (string, bool) {
+	if r.pos >= len(r.args) {
+		return "", false
+	}
+	s := r.args[r.pos]
+	r.pos++
+	return s, true
+}
+
+func (r *argReader) invalid(s string) {
+	if r.err == nil {
+		r.err = fmt.Errorf("%w: %s argument %d %q", ErrInvalidArgument, r.opcode, r.pos, s)
+	}
+}
+
+func (r *argReader) string() string {
+	s, _ := r.next()
+	return s
+}
+
+func (r *argReader) rest() []string {
+	if r.pos >= len(r.args) {
+		return nil
+	}
+	rest := r.args[r.pos:]
+	r.pos = len(r.args)
+	return rest
+}
+
+func (r *argReader) int64() int64 {
+	s, ok := r.next()
+	if !ok {
+		return 0
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		r.invalid(s)
+	}
+	return n
+}
+
+func (r *argReader) int() int {
+	return int(r.int64())
+}
+
+func (r *argReader) float() float64 {
+	s, ok := r.next()
+	if !ok {
+		return 0
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		r.invalid(s)
+	}
+	return f
+}
+
+func (r *argReader) bool() bool {
+	s, ok := r.next()
+	if !ok {
+		return false
+	}
+	switch s {
+	case "1":
+		return true
+	case "0":
+		return false
+	}
+	r.invalid(s)
+	return false
+}
+
+func itoa(n int) string {
+	return strconv.Itoa(n)
+}
+
+func ftoa(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func btoa(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
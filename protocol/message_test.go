@@ -0,0 +1,64 @@
+package protocol
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	messages := []Message{
+		&Arc{Layer: 1, X: 10, Y: 20, Radius: 5, Start: 0, End: 3.14, Negative: true},
+		&Copy{SrcLayer: -1, SrcX: 1, SrcY: 2, SrcWidth: 3, SrcHeight: 4, Mask: 14, DstLayer: 0, DstX: 5, DstY: 6},
+		&Transform{Layer: 2, A: 1, B: 0.5, C: -0.5, D: 1, E: 10, F: 20},
+		&Set{Layer: 0, Property: "miter-limit", Value: "10"},
+		&LayerSize{Layer: 0, Width: 1024, Height: 768},
+		&Ack{Stream: 3, Message: "OK", Status: Success},
+		&Blob{Stream: 3, Data: "aGVsbG8="},
+		&File{Stream: 4, Mimetype: "application/octet-stream", Filename: "a,b;c.txt"},
+		&Img{Stream: 5, Mask: 14, Layer: 0, Mimetype: "image/png", X: 10, Y: 20},
+		&Put{Object: 1, Stream: 6, Mimetype: "text/plain", Name: "/tmp/x"},
+		&Select{Protocol: "rdp"},
+		&Args{Version: "VERSION_1_5_0", Parameters: []string{"hostname", "port"}},
+		&Connect{Values: []string{"VERSION_1_5_0", "10.0.0.1", ""}},
+		&Size{Width: 1920, Height: 1080, DPI: 96},
+		&Size{Width: 800, Height: 600},
+		&HandshakeAudio{Mimetypes: []string{"audio/L8", "audio/L16"}},
+		&Ready{ConnectionID: "$abc"},
+		&Required{Parameters: []string{"username", "password"}},
+		&Error{Message: "Aborted. See logs.", Status: UpstreamNotFound},
+		&Key{Keysym: 65307, Pressed: true},
+		&Mouse{X: 1, Y: 2, Mask: 1},
+		&Mouse{X: 1, Y: 2, Mask: 0, Timestamp: 1700000000000},
+		&Msg{Code: 1, Args: []string{"guest"}},
+		&Sync{Timestamp: 1700000000000, Frames: 2},
+		&Touch{ID: 1, X: 10, Y: 20, RadiusX: 3, RadiusY: 4, Angle: 45.5, Force: 0.5},
+	}
+	for _, m := range messages {
+		instr := m.Marshal()
+		got := reflect.New(reflect.TypeOf(m).Elem()).Interface().(Message)
+		if err := got.Unmarshal(instr); err != nil {
+			t.Fatalf("%s: %v", instr, err)
+		}
+		if !reflect.DeepEqual(got, m) {
+			t.Errorf("%s: got %+v, want %+v", instr, got, m)
+		}
+	}
+}
+
+func TestMessageUnmarshalError(t *testing.T) {
+	var mouse Mouse
+	if err := mouse.Unmarshal(NewInstruction("key", "1", "1")); !errors.Is(err, ErrUnexpectedOpcode) {
+		t.Errorf("got %v, want %v", err, ErrUnexpectedOpcode)
+	}
+	if err := mouse.Unmarshal(NewInstruction("mouse", "1")); !errors.Is(err, ErrMissingArguments) {
+		t.Errorf("got %v, want %v", err, ErrMissingArguments)
+	}
+	if err := mouse.Unmarshal(NewInstruction("mouse", "1", "x", "0")); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("got %v, want %v", err, ErrInvalidArgument)
+	}
+	var key Key
+	if err := key.Unmarshal(NewInstruction("key", "65307", "yes")); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("got %v, want %v", err, ErrInvalidArgument)
+	}
+}
@@ -0,0 +1,90 @@
+package protocol
+
+// Opcodes of all documented instructions
+const (
+	OpAck        = "ack"
+	OpArc        = "arc"
+	OpArgs       = "args"
+	OpArgv       = "argv"
+	OpAudio      = "audio"
+	OpBlob       = "blob"
+	OpBody       = "body"
+	OpCfill      = "cfill"
+	OpClip       = "clip"
+	OpClipboard  = "clipboard"
+	OpClose      = "close"
+	OpConnect    = "connect"
+	OpCopy       = "copy"
+	OpCstroke    = "cstroke"
+	OpCursor     = "cursor"
+	OpCurve      = "curve"
+	OpDisconnect = "disconnect"
+	OpDispose    = "dispose"
+	OpDistort    = "distort"
+	OpEnd        = "end"
+	OpError      = "error"
+	OpFile       = "file"
+	OpFilesystem = "filesystem"
+	OpGet        = "get"
+	OpIdentity   = "identity"
+	OpImage      = "image"
+	OpImg        = "img"
+	OpJpeg       = "jpeg"
+	OpKey        = "key"
+	OpLfill      = "lfill"
+	OpLine       = "line"
+	OpLog        = "log"
+	OpLstroke    = "lstroke"
+	OpMouse      = "mouse"
+	OpMove       = "move"
+	OpMsg        = "msg"
+	OpName       = "name"
+	OpNest       = "nest"
+	OpNop        = "nop"
+	OpPipe       = "pipe"
+	OpPng        = "png"
+	OpPop        = "pop"
+	OpPush       = "push"
+	OpPut        = "put"
+	OpReady      = "ready"
+	OpRect       = "rect"
+	OpRequired   = "required"
+	OpReset      = "reset"
+	OpSelect     = "select"
+	OpSet        = "set"
+	OpShade      = "shade"
+	OpSize       = "size"
+	OpStart      = "start"
+	OpSync       = "sync"
+	OpTimezone   = "timezone"
+	OpTouch      = "touch"
+	OpTransfer   = "transfer"
+	OpTransform  = "transform"
+	OpUndefine   = "undefine"
+	OpVideo      = "video"
+)
+
+// OpInternal is the empty opcode of instructions between the tunnel and guacamole-common-js which never reach guacd,
+// see Guacamole.Tunnel.INTERNAL_DATA_OPCODE. The tunnel UUID is sent as "0.,36.<uuid>;"
+const OpInternal = ""
+
+// InternalPing is the first argument of the internal instruction guacamole-common-js sends to check the tunnel,
+// it is echoed back unchanged
+const InternalPing = "ping"
+
+var opcodes = map[string]bool{
+	OpAck: true, OpArc: true, OpArgs: true, OpArgv: true, OpAudio: true, OpBlob: true, OpBody: true, OpCfill: true,
+	OpClip: true, OpClipboard: true, OpClose: true, OpConnect: true, OpCopy: true, OpCstroke: true, OpCursor: true,
+	OpCurve: true, OpDisconnect: true, OpDispose: true, OpDistort: true, OpEnd: true, OpError: true, OpFile: true,
+	OpFilesystem: true, OpGet: true, OpIdentity: true, OpImage: true, OpImg: true, OpJpeg: true, OpKey: true,
+	OpLfill: true, OpLine: true, OpLog: true, OpLstroke: true, OpMouse: true, OpMove: true, OpMsg: true, OpName: true,
+	OpNest: true, OpNop: true, OpPipe: true, OpPng: true, OpPop: true, OpPush: true, OpPut: true, OpReady: true,
+	OpRect: true, OpRequired: true, OpReset: true, OpSelect: true, OpSet: true, OpShade: true, OpSize: true,
+	OpStart: true, OpSync: true, OpTimezone: true, OpTouch: true, OpTransfer: true, OpTransform: true,
+	OpUndefine: true, OpVideo: true,
+}
+
+// IsOpcode reports whether opcode is one of the documented opcodes above
+func IsOpcode(opcode string) bool {
+	return opcodes[opcode]
+}
@@ -0,0 +1,138 @@
+package protocol
+
+import (
+	"reflect"
+	"strconv"
+)
+
+// https://guacamole.apache.org/doc/gug/configuring-guacamole.html#configuring-connections
+
+// ConnectionParameters is implemented by the typed parameter sets of each protocol.
+// Fields are tagged with the guacd parameter name, zero values are left out so guacd applies its defaults
+type ConnectionParameters interface {
+	Protocol() string
+	ConnectArgs() map[string]string
+}
+
+// WithParameters selects the protocol of p and adds its parameters to the connect arguments
+func WithParameters(p ConnectionParameters) HandshakeOption {
+	return func(c *HandshakeConfig) {
+		c.protocol = p.Protocol()
+		for name, value := range p.ConnectArgs() {
+			c.connectArgs[name] = value
+		}
+	}
+}
+
+// encodeParameters converts the guac tagged fields of the struct v, including embedded structs, to connect arguments
+func encodeParameters(v any) map[string]string {
+	args := make(map[string]string)
+	appendParameters(args, reflect.ValueOf(v))
+	return args
+}
+
+func appendParameters(args map[string]string, v reflect.Value) {
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field, value := t.Field(i), v.Field(i)
+		if field.Anonymous && value.Kind() == reflect.Struct {
+			appendParameters(args, value)
+			continue
+		}
+		name := field.Tag.Get("guac")
+		if name == "" || value.IsZero() {
+			continue
+		}
+		switch value.Kind() {
+		case reflect.String:
+			args[name] = value.String()
+		case reflect.Bool:
+			args[name] = "true"
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			args[name] = strconv.FormatInt(value.Int(), 10)
+		}
+	}
+}
+
+// ColorDepth is the color depth in bits per pixel
+type ColorDepth int
+
+const (
+	ColorDepth8  ColorDepth = 8
+	ColorDepth16 ColorDepth = 16
+	ColorDepth24 ColorDepth = 24
+	ColorDepth32 ColorDepth = 32
+)
+
+// ClipboardParameters restrict clipboard access, supported by all protocols
+type ClipboardParameters struct {
+	DisableCopy  bool `guac:"disable-copy"`
+	DisablePaste bool `guac:"disable-paste"`
+}
+
+// RecordingParameters configure the graphical session recording written by guacd
+type RecordingParameters struct {
+	RecordingPath          string `guac:"recording-path"`
+	RecordingName          string `guac:"recording-name"`
+	CreateRecordingPath    bool   `guac:"create-recording-path"`
+	RecordingExcludeOutput bool   `guac:"recording-exclude-output"`
+	RecordingExcludeMouse  bool   `guac:"recording-exclude-mouse"`
+	RecordingExcludeTouch  bool   `guac:"recording-exclude-touch"`
+	RecordingIncludeKeys   bool   `guac:"recording-include-keys"`
+	RecordingWriteExisting bool   `guac:"recording-write-existing"`
+}
+
+// TypescriptParameters configure the text session recording of terminal protocols
+type TypescriptParameters struct {
+	TypescriptPath          string `guac:"typescript-path"`
+	TypescriptName          string `guac:"typescript-name"`
+	CreateTypescriptPath    bool   `guac:"create-typescript-path"`
+	TypescriptWriteExisting bool   `guac:"typescript-write-existing"`
+}
+
+// Terminal color schemes, a custom scheme such as "foreground:rgb:00/00/00;background:rgb:ff/ff/ff" is also accepted
+const (
+	ColorSchemeBlackWhite = "black-white"
+	ColorSchemeGrayBlack  = "gray-black"
+	ColorSchemeGreenBlack = "green-black"
+	ColorSchemeWhiteBlack = "white-black"
+)
+
+// TerminalParameters configure the terminal emulator of SSH, Telnet and Kubernetes
+type TerminalParameters struct {
+	ColorScheme  string `guac:"color-scheme"`
+	FontName     string `guac:"font-name"`
+	FontSize     int    `guac:"font-size"`
+	Scrollback   int    `guac:"scrollback"`
+	Backspace    int    `guac:"backspace"`
+	TerminalType string `guac:"terminal-type"`
+}
+
+// SFTPParameters enable file transfer over SFTP for RDP and VNC
+type SFTPParameters struct {
+	EnableSFTP              bool   `guac:"enable-sftp"`
+	SFTPHostname            string `guac:"sftp-hostname"`
+	SFTPPort                int    `guac:"sftp-port"`
+	SFTPHostKey             string `guac:"sftp-host-key"`
+	SFTPUsername            string `guac:"sftp-username"`
+	SFTPPassword            string `guac:"sftp-password"`
+	SFTPPrivateKey          string `guac:"sftp-private-key"`
+	SFTPPassphrase          string `guac:"sftp-passphrase"`
+	SFTPDirectory           string `guac:"sftp-directory"`
+	SFTPRootDirectory       string `guac:"sftp-root-directory"`
+	SFTPServerAliveInterval int    `guac:"sftp-server-alive-interval"`
+	SFTPDisableDownload     bool   `guac:"sftp-disable-download"`
+	SFTPDisableUpload       bool   `guac:"sftp-disable-upload"`
+}
+
+// WakeOnLANParameters send a magic packet before connecting
+type WakeOnLANParameters struct {
+	WOLSendPacket    bool   `guac:"wol-send-packet"`
+	WOLMacAddr       string `guac:"wol-mac-addr"`
+	WOLBroadcastAddr string `guac:"wol-broadcast-addr"`
+	WOLUDPPort       int    `guac:"wol-udp-port"`
+	WOLWaitTime      int    `guac:"wol-wait-time"`
+}
@@ -0,0 +1,46 @@
+package protocol
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParametersConnectArgs(t *testing.T) {
+	rdp := RDPParameters{
+		Hostname:    "10.0.0.1",
+		Port:        3389,
+		Security:    RDPSecurityNLA,
+		IgnoreCert:  true,
+		ColorDepth:  ColorDepth16,
+		EnableDrive: true,
+		DrivePath:   "/drive",
+		SFTPParameters: SFTPParameters{
+			EnableSFTP:   true,
+			SFTPHostname: "10.0.0.2",
+		},
+	}
+	want := map[string]string{
+		"hostname":      "10.0.0.1",
+		"port":          "3389",
+		"security":      "nla",
+		"ignore-cert":   "true",
+		"color-depth":   "16",
+		"enable-drive":  "true",
+		"drive-path":    "/drive",
+		"enable-sftp":   "true",
+		"sftp-hostname": "10.0.0.2",
+	}
+	if got := rdp.ConnectArgs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	ssh := SSHParameters{Hostname: "10.0.0.3", TerminalParameters: TerminalParameters{ColorScheme: ColorSchemeGreenBlack, Scrollback: 5000}}
+	config := NewHandshakeConfig(nil, WithParameters(ssh))
+	if config.SelectInstruction() != NewInstruction("select", "ssh") {
+		t.Errorf("unexpected select %s", config.SelectInstruction())
+	}
+	args := NewInstruction("args", "VERSION_1_5_0", "hostname", "color-scheme", "scrollback", "font-size")
+	if got, want := config.ConnectInstruction(args.Args()), NewInstruction("connect", "VERSION_1_5_0", "10.0.0.3", "green-black", "5000", ""); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,104 @@
+package protocol
+
+// RDPSecurity is the security mode of an RDP connection
+type RDPSecurity string
+
+const (
+	RDPSecurityAny       RDPSecurity = "any"
+	RDPSecurityNLA       RDPSecurity = "nla"
+	RDPSecurityNLAExt    RDPSecurity = "nla-ext"
+	RDPSecurityTLS       RDPSecurity = "tls"
+	RDPSecurityVMConnect RDPSecurity = "vmconnect"
+	RDPSecurityRDP       RDPSecurity = "rdp"
+)
+
+// ResizeMethod is how the RDP display is resized when the browser window changes size
+type ResizeMethod string
+
+const (
+	ResizeMethodDisplayUpdate ResizeMethod = "display-update"
+	ResizeMethodReconnect     ResizeMethod = "reconnect"
+)
+
+// RDPParameters https://guacamole.apache.org/doc/gug/configuring-guacamole.html#rdp
+type RDPParameters struct {
+	Hostname    string      `guac:"hostname"`
+	Port        int         `guac:"port"`
+	Timeout     int         `guac:"timeout"`
+	Username    string      `guac:"username"`
+	Password    string      `guac:"password"`
+	Domain      string      `guac:"domain"`
+	Security    RDPSecurity `guac:"security"`
+	IgnoreCert  bool        `guac:"ignore-cert"`
+	DisableAuth bool        `guac:"disable-auth"`
+
+	// Remote Desktop Gateway
+	GatewayHostname string `guac:"gateway-hostname"`
+	GatewayPort     int    `guac:"gateway-port"`
+	GatewayUsername string `guac:"gateway-username"`
+	GatewayPassword string `guac:"gateway-password"`
+	GatewayDomain   string `guac:"gateway-domain"`
+
+	// RemoteApp
+	RemoteApp     string `guac:"remote-app"`
+	RemoteAppDir  string `guac:"remote-app-dir"`
+	RemoteAppArgs string `guac:"remote-app-args"`
+
+	// Session
+	ClientName     string `guac:"client-name"`
+	Console        bool   `guac:"console"`
+	InitialProgram string `guac:"initial-program"`
+	ServerLayout   string `guac:"server-layout"`
+	Timezone       string `guac:"timezone"`
+
+	// Display
+	ColorDepth    ColorDepth   `guac:"color-depth"`
+	ResizeMethod  ResizeMethod `guac:"resize-method"`
+	ForceLossless bool         `guac:"force-lossless"`
+	ReadOnly      bool         `guac:"read-only"`
+	EnableTouch   bool         `guac:"enable-touch"`
+
+	// Device redirection
+	EnableDrive        bool   `guac:"enable-drive"`
+	DriveName          string `guac:"drive-name"`
+	DrivePath          string `guac:"drive-path"`
+	CreateDrivePath    bool   `guac:"create-drive-path"`
+	DisableDownload    bool   `guac:"disable-download"`
+	DisableUpload      bool   `guac:"disable-upload"`
+	EnablePrinting     bool   `guac:"enable-printing"`
+	PrinterName        string `guac:"printer-name"`
+	EnableAudioInput   bool   `guac:"enable-audio-input"`
+	DisableAudio       bool   `guac:"disable-audio"`
+	ConsoleAudio       bool   `guac:"console-audio"`
+	StaticChannels     string `guac:"static-channels"`
+	NormalizeClipboard string `guac:"normalize-clipboard"`
+
+	// Performance
+	EnableWallpaper          bool `guac:"enable-wallpaper"`
+	EnableTheming            bool `guac:"enable-theming"`
+	EnableFontSmoothing      bool `guac:"enable-font-smoothing"`
+	EnableFullWindowDrag     bool `guac:"enable-full-window-drag"`
+	EnableDesktopComposition bool `guac:"enable-desktop-composition"`
+	EnableMenuAnimations     bool `guac:"enable-menu-animations"`
+	DisableBitmapCaching     bool `guac:"disable-bitmap-caching"`
+	DisableOffscreenCaching  bool `guac:"disable-offscreen-caching"`
+	DisableGlyphCaching      bool `guac:"disable-glyph-caching"`
+
+	// Preconnection PDU and load balancing
+	PreconnectionId   int    `guac:"preconnection-id"`
+	PreconnectionBlob string `guac:"preconnection-blob"`
+	LoadBalanceInfo   string `guac:"load-balance-info"`
+
+	SFTPParameters
+	RecordingParameters
+	ClipboardParameters
+	WakeOnLANParameters
+}
+
+func (p RDPParameters) Protocol() string {
+	return "rdp"
+}
+
+func (p RDPParameters) ConnectArgs() map[string]string {
+	return encodeParameters(p)
+}
@@ -0,0 +1,435 @@
+package protocol
+
+import (
+	"strconv"
+	"strings"
+)
+
+const versionPrefix = "VERSION_"
+
+// Handshake and client/server control instructions
+// https://guacamole.apache.org/doc/gug/protocol-reference.html#handshake-instructions
+
+// Select is the first handshake instruction, choosing a protocol or an existing connection ID to join
+type Select struct {
+	Protocol string
+}
+
+func (s Select) Opcode() string {
+	return OpSelect
+}
+
+func (s Select) Marshal() Instruction {
+	return NewInstruction(OpSelect, s.Protocol)
+}
+
+func (s *Select) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpSelect, 1)
+	if err != nil {
+		return err
+	}
+	s.Protocol = ar.string()
+	return ar.err
+}
+
+// Args is the server response to select, listing the names of the connection parameters it accepts.
+// Since protocol version 1.1.0 the first element is the protocol version, e.g. VERSION_1_5_0
+type Args struct {
+	Version    string
+	Parameters []string
+}
+
+func (a Args) Opcode() string {
+	return OpArgs
+}
+
+func (a Args) Marshal() Instruction {
+	if a.Version == "" {
+		return NewInstruction(OpArgs, a.Parameters...)
+	}
+	return NewInstruction(OpArgs, append([]string{a.Version}, a.Parameters...)...)
+}
+
+func (a *Args) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpArgs, 0)
+	if err != nil {
+		return err
+	}
+	a.Version = ""
+	a.Parameters = ar.rest()
+	if len(a.Parameters) > 0 && strings.HasPrefix(a.Parameters[0], versionPrefix) {
+		a.Version = a.Parameters[0]
+		a.Parameters = a.Parameters[1:]
+	}
+	return ar.err
+}
+
+// Connect completes the handshake with the parameter values in the order requested by args
+type Connect struct {
+	Values []string
+}
+
+func (c Connect) Opcode() string {
+	return OpConnect
+}
+
+func (c Connect) Marshal() Instruction {
+	return NewInstruction(OpConnect, c.Values...)
+}
+
+func (c *Connect) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpConnect, 0)
+	if err != nil {
+		return err
+	}
+	c.Values = ar.rest()
+	return ar.err
+}
+
+// Size is the client side size instruction, sending the optimal display size. DPI is only sent during the handshake
+type Size struct {
+	Width  int
+	Height int
+	DPI    int
+}
+
+func (s Size) Opcode() string {
+	return OpSize
+}
+
+func (s Size) Marshal() Instruction {
+	args := []string{itoa(s.Width), itoa(s.Height)}
+	if s.DPI != 0 {
+		args = append(args, itoa(s.DPI))
+	}
+	return NewInstruction(OpSize, args...)
+}
+
+func (s *Size) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpSize, 2)
+	if err != nil {
+		return err
+	}
+	s.Width = ar.int()
+	s.Height = ar.int()
+	s.DPI = ar.int()
+	return ar.err
+}
+
+// HandshakeAudio lists the audio mimetypes supported by the client during the handshake
+type HandshakeAudio struct {
+	Mimetypes []string
+}
+
+func (h HandshakeAudio) Opcode() string {
+	return OpAudio
+}
+
+func (h HandshakeAudio) Marshal() Instruction {
+	return NewInstruction(OpAudio, h.Mimetypes...)
+}
+
+func (h *HandshakeAudio) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpAudio, 0)
+	if err != nil {
+		return err
+	}
+	h.Mimetypes = ar.rest()
+	return ar.err
+}
+
+// HandshakeVideo lists the video mimetypes supported by the client during the handshake
+type HandshakeVideo struct {
+	Mimetypes []string
+}
+
+func (h HandshakeVideo) Opcode() string {
+	return OpVideo
+}
+
+func (h HandshakeVideo) Marshal() Instruction {
+	return NewInstruction(OpVideo, h.Mimetypes...)
+}
+
+func (h *HandshakeVideo) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpVideo, 0)
+	if err != nil {
+		return err
+	}
+	h.Mimetypes = ar.rest()
+	return ar.err
+}
+
+// HandshakeImage lists the image mimetypes supported by the client during the handshake
+type HandshakeImage struct {
+	Mimetypes []string
+}
+
+func (h HandshakeImage) Opcode() string {
+	return OpImage
+}
+
+func (h HandshakeImage) Marshal() Instruction {
+	return NewInstruction(OpImage, h.Mimetypes...)
+}
+
+func (h *HandshakeImage) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpImage, 0)
+	if err != nil {
+		return err
+	}
+	h.Mimetypes = ar.rest()
+	return ar.err
+}
+
+// Timezone sends the client timezone during the handshake, e.g. Asia/Shanghai
+type Timezone struct {
+	Timezone string
+}
+
+func (t Timezone) Opcode() string {
+	return OpTimezone
+}
+
+func (t Timezone) Marshal() Instruction {
+	return NewInstruction(OpTimezone, t.Timezone)
+}
+
+func (t *Timezone) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpTimezone, 1)
+	if err != nil {
+		return err
+	}
+	t.Timezone = ar.string()
+	return ar.err
+}
+
+// Name sends the display name of the user during the handshake
+type Name struct {
+	Name string
+}
+
+func (n Name) Opcode() string {
+	return OpName
+}
+
+func (n Name) Marshal() Instruction {
+	return NewInstruction(OpName, n.Name)
+}
+
+func (n *Name) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpName, 1)
+	if err != nil {
+		return err
+	}
+	n.Name = ar.string()
+	return ar.err
+}
+
+// Ready is sent by the server once the connection is established
+type Ready struct {
+	ConnectionID string
+}
+
+func (r Ready) Opcode() string {
+	return OpReady
+}
+
+func (r Ready) Marshal() Instruction {
+	return NewInstruction(OpReady, r.ConnectionID)
+}
+
+func (r *Ready) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpReady, 1)
+	if err != nil {
+		return err
+	}
+	r.ConnectionID = ar.string()
+	return ar.err
+}
+
+// Required is sent by the server when additional connection parameters, usually credentials, are needed
+type Required struct {
+	Parameters []string
+}
+
+func (r Required) Opcode() string {
+	return OpRequired
+}
+
+func (r Required) Marshal() Instruction {
+	return NewInstruction(OpRequired, r.Parameters...)
+}
+
+func (r *Required) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpRequired, 0)
+	if err != nil {
+		return err
+	}
+	r.Parameters = ar.rest()
+	return ar.err
+}
+
+// Key sends a key press or release
+type Key struct {
+	Keysym  int
+	Pressed bool
+}
+
+func (k Key) Opcode() string {
+	return OpKey
+}
+
+func (k Key) Marshal() Instruction {
+	return NewInstruction(OpKey, itoa(k.Keysym), btoa(k.Pressed))
+}
+
+func (k *Key) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpKey, 2)
+	if err != nil {
+		return err
+	}
+	k.Keysym = ar.int()
+	k.Pressed = ar.bool()
+	return ar.err
+}
+
+// Log sends a message to be logged by the receiving side
+type Log struct {
+	Message string
+}
+
+func (l Log) Opcode() string {
+	return OpLog
+}
+
+func (l Log) Marshal() Instruction {
+	return NewInstruction(OpLog, l.Message)
+}
+
+func (l *Log) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpLog, 1)
+	if err != nil {
+		return err
+	}
+	l.Message = ar.string()
+	return ar.err
+}
+
+// Mouse sends the mouse position and button mask. Timestamp is only sent by the server
+type Mouse struct {
+	X         int
+	Y         int
+	Mask      int
+	Timestamp int64
+}
+
+func (m Mouse) Opcode() string {
+	return OpMouse
+}
+
+func (m Mouse) Marshal() Instruction {
+	args := []string{itoa(m.X), itoa(m.Y), itoa(m.Mask)}
+	if m.Timestamp != 0 {
+		args = append(args, strconv.FormatInt(m.Timestamp, 10))
+	}
+	return NewInstruction(OpMouse, args...)
+}
+
+func (m *Mouse) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpMouse, 3)
+	if err != nil {
+		return err
+	}
+	m.X = ar.int()
+	m.Y = ar.int()
+	m.Mask = ar.int()
+	m.Timestamp = ar.int64()
+	return ar.err
+}
+
+// Msg sends a notification message to the client
+type Msg struct {
+	Code int
+	Args []string
+}
+
+func (m Msg) Opcode() string {
+	return OpMsg
+}
+
+func (m Msg) Marshal() Instruction {
+	return NewInstruction(OpMsg, append([]string{itoa(m.Code)}, m.Args...)...)
+}
+
+func (m *Msg) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpMsg, 1)
+	if err != nil {
+		return err
+	}
+	m.Code = ar.int()
+	m.Args = ar.rest()
+	return ar.err
+}
+
+// Sync reports that all preceding operations up to the timestamp have been processed
+type Sync struct {
+	Timestamp int64
+	Frames    int
+}
+
+func (s Sync) Opcode() string {
+	return OpSync
+}
+
+func (s Sync) Marshal() Instruction {
+	args := []string{strconv.FormatInt(s.Timestamp, 10)}
+	if s.Frames != 0 {
+		args = append(args, itoa(s.Frames))
+	}
+	return NewInstruction(OpSync, args...)
+}
+
+func (s *Sync) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpSync, 1)
+	if err != nil {
+		return err
+	}
+	s.Timestamp = ar.int64()
+	s.Frames = ar.int()
+	return ar.err
+}
+
+// Touch sends the state of a touch contact
+type Touch struct {
+	ID      int
+	X       int
+	Y       int
+	RadiusX int
+	RadiusY int
+	Angle   float64
+	Force   float64
+}
+
+func (t Touch) Opcode() string {
+	return OpTouch
+}
+
+func (t Touch) Marshal() Instruction {
+	return NewInstruction(OpTouch, itoa(t.ID), itoa(t.X), itoa(t.Y), itoa(t.RadiusX), itoa(t.RadiusY), ftoa(t.Angle), ftoa(t.Force))
+}
+
+func (t *Touch) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpTouch, 7)
+	if err != nil {
+		return err
+	}
+	t.ID = ar.int()
+	t.X = ar.int()
+	t.Y = ar.int()
+	t.RadiusX = ar.int()
+	t.RadiusY = ar.int()
+	t.Angle = ar.float()
+	t.Force = ar.float()
+	return ar.err
+}
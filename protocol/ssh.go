@@ -0,0 +1,38 @@
+package protocol
+
+// SSHParameters https://guacamole.apache.org/doc/gug/configuring-guacamole.html#ssh
+type SSHParameters struct {
+	Hostname            string `guac:"hostname"`
+	Port                int    `guac:"port"`
+	HostKey             string `guac:"host-key"`
+	Username            string `guac:"username"`
+	Password            string `guac:"password"`
+	PrivateKey          string `guac:"private-key"`
+	Passphrase          string `guac:"passphrase"`
+	PublicKey           string `guac:"public-key"`
+	Command             string `guac:"command"`
+	Locale              string `guac:"locale"`
+	Timezone            string `guac:"timezone"`
+	ServerAliveInterval int    `guac:"server-alive-interval"`
+	ReadOnly            bool   `guac:"read-only"`
+
+	// SFTP over the same SSH connection
+	EnableSFTP          bool   `guac:"enable-sftp"`
+	SFTPRootDirectory   string `guac:"sftp-root-directory"`
+	SFTPDisableDownload bool   `guac:"sftp-disable-download"`
+	SFTPDisableUpload   bool   `guac:"sftp-disable-upload"`
+
+	TerminalParameters
+	TypescriptParameters
+	RecordingParameters
+	ClipboardParameters
+	WakeOnLANParameters
+}
+
+func (p SSHParameters) Protocol() string {
+	return "ssh"
+}
+
+func (p SSHParameters) ConnectArgs() map[string]string {
+	return encodeParameters(p)
+}
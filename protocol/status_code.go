@@ -1,6 +1,7 @@
 package protocol
 
 import (
+	"net/http"
 	"strconv"
 )
 
@@ -123,3 +124,105 @@ func (s StatusCode) String() string {
 		return code + "_UNKNOWN"
 	}
 }
+
+// IsClientError reports whether the status is a 0x03xx code, caused by the client or the user
+func (s StatusCode) IsClientError() bool {
+	return s >= ClientBadRequest && s < 0x0400
+}
+
+// IsUpstreamError reports whether the status originates from the upstream (remote desktop) server,
+// including the sessions it closed
+func (s StatusCode) IsUpstreamError() bool {
+	switch s {
+	case UpstreamTimeout, UpstreamError, UpstreamNotFound, UpstreamUnavailable,
+		SessionConflict, SessionTimeout, SessionClosed:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsServerError reports whether the status is a 0x01xx or 0x02xx code raised by guacd or the tunnel itself,
+// excluding upstream errors
+func (s StatusCode) IsServerError() bool {
+	return s >= Unsupported && s < ClientBadRequest && !s.IsUpstreamError()
+}
+
+// IsRetryable reports whether reconnecting later may succeed without changing anything
+func (s StatusCode) IsRetryable() bool {
+	switch s {
+	case ServerError, ServerBusy, UpstreamTimeout, UpstreamError, UpstreamNotFound, UpstreamUnavailable,
+		ClientTimeout, ClientTooMany:
+		return true
+	default:
+		return false
+	}
+}
+
+// ShouldPromptCredentials reports whether the user should be asked for (other) credentials before reconnecting
+func (s StatusCode) ShouldPromptCredentials() bool {
+	return s == ClientUnauthorized
+}
+
+// HTTPStatus returns the HTTP status code guacamole-client uses for the status
+func (s StatusCode) HTTPStatus() int {
+	switch s {
+	case Success:
+		return http.StatusOK
+	case Unsupported:
+		return http.StatusNotImplemented
+	case ServerBusy:
+		return http.StatusServiceUnavailable
+	case UpstreamTimeout:
+		return http.StatusGatewayTimeout
+	case UpstreamError, UpstreamNotFound, UpstreamUnavailable:
+		return http.StatusBadGateway
+	case ResourceNotFound, ResourceClosed, SessionClosed:
+		return http.StatusNotFound
+	case ResourceConflict, SessionConflict:
+		return http.StatusConflict
+	case SessionTimeout, ClientTimeout:
+		return http.StatusRequestTimeout
+	case ClientBadRequest:
+		return http.StatusBadRequest
+	case ClientUnauthorized, ClientForbidden:
+		return http.StatusForbidden
+	case ClientOverrun:
+		return http.StatusRequestEntityTooLarge
+	case ClientBadType:
+		return http.StatusUnsupportedMediaType
+	case ClientTooMany:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// WebSocket close codes, https://www.rfc-editor.org/rfc/rfc6455#section-7.4.1
+const (
+	wsCloseNormal          = 1000
+	wsCloseProtocolError   = 1002
+	wsCloseUnsupportedData = 1003
+	wsClosePolicyViolation = 1008
+	wsCloseMessageTooBig   = 1009
+	wsCloseInternalError   = 1011
+)
+
+// WebSocketCloseCode returns the WebSocket close code guacamole-client uses for the status,
+// the status itself is sent as the close reason
+func (s StatusCode) WebSocketCloseCode() int {
+	switch s {
+	case Success:
+		return wsCloseNormal
+	case ServerBusy, ResourceConflict, SessionConflict, ClientUnauthorized, ClientForbidden, ClientTooMany:
+		return wsClosePolicyViolation
+	case ResourceNotFound, ResourceClosed, SessionTimeout, SessionClosed, ClientBadRequest, ClientTimeout:
+		return wsCloseProtocolError
+	case ClientOverrun:
+		return wsCloseMessageTooBig
+	case ClientBadType:
+		return wsCloseUnsupportedData
+	default:
+		return wsCloseInternalError
+	}
+}
@@ -0,0 +1,425 @@
+package protocol
+
+import (
+	"encoding/base64"
+	"strconv"
+)
+
+// Streaming and object instructions
+// https://guacamole.apache.org/doc/gug/protocol-reference.html#streaming-instructions
+
+// Ack acknowledges the receipt of a blob or the opening of a stream
+type Ack struct {
+	Stream  int
+	Message string
+	Status  StatusCode
+}
+
+func (a Ack) Opcode() string {
+	return OpAck
+}
+
+func (a Ack) Marshal() Instruction {
+	return NewInstruction(OpAck, itoa(a.Stream), a.Message, strconv.FormatInt(int64(a.Status), 10))
+}
+
+func (a *Ack) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpAck, 3)
+	if err != nil {
+		return err
+	}
+	a.Stream = ar.int()
+	a.Message = ar.string()
+	a.Status = StatusCode(ar.int64())
+	return ar.err
+}
+
+// Argv opens a stream to update the value of the named connection parameter
+type Argv struct {
+	Stream   int
+	Mimetype string
+	Name     string
+}
+
+func (a Argv) Opcode() string {
+	return OpArgv
+}
+
+func (a Argv) Marshal() Instruction {
+	return NewInstruction(OpArgv, itoa(a.Stream), a.Mimetype, a.Name)
+}
+
+func (a *Argv) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpArgv, 3)
+	if err != nil {
+		return err
+	}
+	a.Stream = ar.int()
+	a.Mimetype = ar.string()
+	a.Name = ar.string()
+	return ar.err
+}
+
+// Audio opens an audio stream. It is not the handshake audio instruction, see HandshakeAudio
+type Audio struct {
+	Stream   int
+	Mimetype string
+}
+
+func (a Audio) Opcode() string {
+	return OpAudio
+}
+
+func (a Audio) Marshal() Instruction {
+	return NewInstruction(OpAudio, itoa(a.Stream), a.Mimetype)
+}
+
+func (a *Audio) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpAudio, 2)
+	if err != nil {
+		return err
+	}
+	a.Stream = ar.int()
+	a.Mimetype = ar.string()
+	return ar.err
+}
+
+// Blob sends base64 encoded data along an open stream
+type Blob struct {
+	Stream int
+	Data   string
+}
+
+func (b Blob) Opcode() string {
+	return OpBlob
+}
+
+func (b Blob) Marshal() Instruction {
+	return NewInstruction(OpBlob, itoa(b.Stream), b.Data)
+}
+
+func (b *Blob) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpBlob, 2)
+	if err != nil {
+		return err
+	}
+	b.Stream = ar.int()
+	b.Data = ar.string()
+	return ar.err
+}
+
+// NewBlob base64 encodes data into a Blob of the stream
+func NewBlob(stream int, data []byte) Blob {
+	return Blob{Stream: stream, Data: base64.StdEncoding.EncodeToString(data)}
+}
+
+// Bytes returns the base64 decoded data
+func (b Blob) Bytes() ([]byte, error) {
+	return base64.StdEncoding.DecodeString(b.Data)
+}
+
+// Clipboard opens a stream carrying the new clipboard contents
+type Clipboard struct {
+	Stream   int
+	Mimetype string
+}
+
+func (c Clipboard) Opcode() string {
+	return OpClipboard
+}
+
+func (c Clipboard) Marshal() Instruction {
+	return NewInstruction(OpClipboard, itoa(c.Stream), c.Mimetype)
+}
+
+func (c *Clipboard) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpClipboard, 2)
+	if err != nil {
+		return err
+	}
+	c.Stream = ar.int()
+	c.Mimetype = ar.string()
+	return ar.err
+}
+
+// End closes the stream
+type End struct {
+	Stream int
+}
+
+func (e End) Opcode() string {
+	return OpEnd
+}
+
+func (e End) Marshal() Instruction {
+	return NewInstruction(OpEnd, itoa(e.Stream))
+}
+
+func (e *End) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpEnd, 1)
+	if err != nil {
+		return err
+	}
+	e.Stream = ar.int()
+	return ar.err
+}
+
+// File opens a stream carrying the contents of the named file
+type File struct {
+	Stream   int
+	Mimetype string
+	Filename string
+}
+
+func (f File) Opcode() string {
+	return OpFile
+}
+
+func (f File) Marshal() Instruction {
+	return NewInstruction(OpFile, itoa(f.Stream), f.Mimetype, f.Filename)
+}
+
+func (f *File) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpFile, 3)
+	if err != nil {
+		return err
+	}
+	f.Stream = ar.int()
+	f.Mimetype = ar.string()
+	f.Filename = ar.string()
+	return ar.err
+}
+
+// Img opens a stream carrying image data to be drawn to the layer
+type Img struct {
+	Stream   int
+	Mask     int
+	Layer    int
+	Mimetype string
+	X        int
+	Y        int
+}
+
+func (i Img) Opcode() string {
+	return OpImg
+}
+
+func (i Img) Marshal() Instruction {
+	return NewInstruction(OpImg, itoa(i.Stream), itoa(i.Mask), itoa(i.Layer), i.Mimetype, itoa(i.X), itoa(i.Y))
+}
+
+func (i *Img) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpImg, 6)
+	if err != nil {
+		return err
+	}
+	i.Stream = ar.int()
+	i.Mask = ar.int()
+	i.Layer = ar.int()
+	i.Mimetype = ar.string()
+	i.X = ar.int()
+	i.Y = ar.int()
+	return ar.err
+}
+
+// Nest sends part of an instruction belonging to a nested stream. Deprecated
+type Nest struct {
+	Index int
+	Data  string
+}
+
+func (n Nest) Opcode() string {
+	return OpNest
+}
+
+func (n Nest) Marshal() Instruction {
+	return NewInstruction(OpNest, itoa(n.Index), n.Data)
+}
+
+func (n *Nest) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpNest, 2)
+	if err != nil {
+		return err
+	}
+	n.Index = ar.int()
+	n.Data = ar.string()
+	return ar.err
+}
+
+// Pipe opens a named pipe stream
+type Pipe struct {
+	Stream   int
+	Mimetype string
+	Name     string
+}
+
+func (p Pipe) Opcode() string {
+	return OpPipe
+}
+
+func (p Pipe) Marshal() Instruction {
+	return NewInstruction(OpPipe, itoa(p.Stream), p.Mimetype, p.Name)
+}
+
+func (p *Pipe) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpPipe, 3)
+	if err != nil {
+		return err
+	}
+	p.Stream = ar.int()
+	p.Mimetype = ar.string()
+	p.Name = ar.string()
+	return ar.err
+}
+
+// Video opens a video stream. It is not the handshake video instruction, see HandshakeVideo
+type Video struct {
+	Stream   int
+	Layer    int
+	Mimetype string
+}
+
+func (v Video) Opcode() string {
+	return OpVideo
+}
+
+func (v Video) Marshal() Instruction {
+	return NewInstruction(OpVideo, itoa(v.Stream), itoa(v.Layer), v.Mimetype)
+}
+
+func (v *Video) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpVideo, 3)
+	if err != nil {
+		return err
+	}
+	v.Stream = ar.int()
+	v.Layer = ar.int()
+	v.Mimetype = ar.string()
+	return ar.err
+}
+
+// Body opens a stream carrying the body of the requested object stream
+type Body struct {
+	Object   int
+	Stream   int
+	Mimetype string
+	Name     string
+}
+
+func (b Body) Opcode() string {
+	return OpBody
+}
+
+func (b Body) Marshal() Instruction {
+	return NewInstruction(OpBody, itoa(b.Object), itoa(b.Stream), b.Mimetype, b.Name)
+}
+
+func (b *Body) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpBody, 4)
+	if err != nil {
+		return err
+	}
+	b.Object = ar.int()
+	b.Stream = ar.int()
+	b.Mimetype = ar.string()
+	b.Name = ar.string()
+	return ar.err
+}
+
+// Filesystem allocates an object exposing a filesystem
+type Filesystem struct {
+	Object int
+	Name   string
+}
+
+func (f Filesystem) Opcode() string {
+	return OpFilesystem
+}
+
+func (f Filesystem) Marshal() Instruction {
+	return NewInstruction(OpFilesystem, itoa(f.Object), f.Name)
+}
+
+func (f *Filesystem) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpFilesystem, 2)
+	if err != nil {
+		return err
+	}
+	f.Object = ar.int()
+	f.Name = ar.string()
+	return ar.err
+}
+
+// Get requests the body of the named object stream
+type Get struct {
+	Object int
+	Name   string
+}
+
+func (g Get) Opcode() string {
+	return OpGet
+}
+
+func (g Get) Marshal() Instruction {
+	return NewInstruction(OpGet, itoa(g.Object), g.Name)
+}
+
+func (g *Get) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpGet, 2)
+	if err != nil {
+		return err
+	}
+	g.Object = ar.int()
+	g.Name = ar.string()
+	return ar.err
+}
+
+// Put opens a stream writing to the named object stream
+type Put struct {
+	Object   int
+	Stream   int
+	Mimetype string
+	Name     string
+}
+
+func (p Put) Opcode() string {
+	return OpPut
+}
+
+func (p Put) Marshal() Instruction {
+	return NewInstruction(OpPut, itoa(p.Object), itoa(p.Stream), p.Mimetype, p.Name)
+}
+
+func (p *Put) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpPut, 4)
+	if err != nil {
+		return err
+	}
+	p.Object = ar.int()
+	p.Stream = ar.int()
+	p.Mimetype = ar.string()
+	p.Name = ar.string()
+	return ar.err
+}
+
+// Undefine releases the object
+type Undefine struct {
+	Object int
+}
+
+func (u Undefine) Opcode() string {
+	return OpUndefine
+}
+
+func (u Undefine) Marshal() Instruction {
+	return NewInstruction(OpUndefine, itoa(u.Object))
+}
+
+func (u *Undefine) Unmarshal(instr Instruction) error {
+	ar, err := newArgReader(instr, OpUndefine, 1)
+	if err != nil {
+		return err
+	}
+	u.Object = ar.int()
+	return ar.err
+}
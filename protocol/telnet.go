@@ -0,0 +1,28 @@
+package protocol
+
+// TelnetParameters https://guacamole.apache.org/doc/gug/configuring-guacamole.html#telnet
+type TelnetParameters struct {
+	Hostname          string `guac:"hostname"`
+	Port              int    `guac:"port"`
+	Username          string `guac:"username"`
+	Password          string `guac:"password"`
+	UsernameRegex     string `guac:"username-regex"`
+	PasswordRegex     string `guac:"password-regex"`
+	LoginSuccessRegex string `guac:"login-success-regex"`
+	LoginFailureRegex string `guac:"login-failure-regex"`
+	ReadOnly          bool   `guac:"read-only"`
+
+	TerminalParameters
+	TypescriptParameters
+	RecordingParameters
+	ClipboardParameters
+	WakeOnLANParameters
+}
+
+func (p TelnetParameters) Protocol() string {
+	return "telnet"
+}
+
+func (p TelnetParameters) ConnectArgs() map[string]string {
+	return encodeParameters(p)
+}
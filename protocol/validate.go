@@ -0,0 +1,100 @@
+package protocol
+
+import (
+	"slices"
+	"sort"
+	"strings"
+)
+
+// screenArgs are always set by HandshakeConfig, whether the protocol accepts them or not
+var screenArgs = map[string]bool{"width": true, "height": true, "dpi": true}
+
+// requiredArgs lists the parameters each protocol cannot connect without
+var requiredArgs = map[string][]string{
+	"rdp":        {"hostname"},
+	"vnc":        {"hostname"},
+	"ssh":        {"hostname"},
+	"telnet":     {"hostname"},
+	"kubernetes": {"hostname", "pod"},
+}
+
+// enumArgs lists the accepted values of enumerated parameters
+var enumArgs = map[string][]string{
+	"security":      {"any", "nla", "nla-ext", "tls", "vmconnect", "rdp"},
+	"color-depth":   {"8", "16", "24", "32"},
+	"resize-method": {"display-update", "reconnect"},
+	"cursor":        {"local", "remote"},
+}
+
+// ValidationError lists the problems found in the connect arguments
+type ValidationError struct {
+	// Unknown arguments are not requested by guacd, usually misspelled parameter names
+	Unknown []string
+	// Missing arguments are requested and required but empty
+	Missing []string
+	// Invalid arguments have a value outside of the accepted values, keyed by name
+	Invalid map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	var problems []string
+	if len(e.Unknown) > 0 {
+		problems = append(problems, "unknown "+strings.Join(e.Unknown, ", "))
+	}
+	if len(e.Missing) > 0 {
+		problems = append(problems, "missing "+strings.Join(e.Missing, ", "))
+	}
+	if len(e.Invalid) > 0 {
+		var invalid []string
+		for name, value := range e.Invalid {
+			invalid = append(invalid, name+"="+value)
+		}
+		sort.Strings(invalid)
+		problems = append(problems, "invalid "+strings.Join(invalid, ", "))
+	}
+	return "invalid connect arguments: " + strings.Join(problems, "; ")
+}
+
+// WithValidation makes Tunnel.Handshake validate the connect arguments against the args sent by guacd
+// and fail with a *ValidationError instead of sending connect
+func WithValidation() HandshakeOption {
+	return func(c *HandshakeConfig) {
+		c.validate = true
+	}
+}
+
+// ValidationEnabled reports whether WithValidation was given
+func (h *HandshakeConfig) ValidationEnabled() bool {
+	return h.validate
+}
+
+// Validate checks the connect arguments against the parameter names in args,
+// it returns a *ValidationError if any argument is unknown, missing or invalid
+func (h *HandshakeConfig) Validate(args []Element) error {
+	requested := make(map[string]bool, len(args))
+	for _, arg := range args {
+		requested[arg.Value()] = true
+	}
+	e := &ValidationError{Invalid: make(map[string]string)}
+	for name, value := range h.connectArgs {
+		if !requested[name] && !screenArgs[name] {
+			e.Unknown = append(e.Unknown, name)
+		}
+		if accepted, ok := enumArgs[name]; ok && value != "" && !slices.Contains(accepted, value) {
+			e.Invalid[name] = value
+		}
+	}
+	// joining users share the parameters of the existing connection, a reverse VNC connection has no host to dial
+	if !h.IsJoin() && h.connectArgs["reverse-connect"] != "true" {
+		for _, name := range requiredArgs[h.protocol] {
+			if requested[name] && h.connectArgs[name] == "" {
+				e.Missing = append(e.Missing, name)
+			}
+		}
+	}
+	if len(e.Unknown) == 0 && len(e.Missing) == 0 && len(e.Invalid) == 0 {
+		return nil
+	}
+	sort.Strings(e.Unknown)
+	return e
+}
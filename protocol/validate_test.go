@@ -0,0 +1,31 @@
+package protocol
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	args := NewInstruction("args", "VERSION_1_5_0", "hostname", "port", "security", "color-depth", "width", "height", "dpi").Args()
+
+	config := NewHandshakeConfig(map[string]string{"hostnme": "10.0.0.1", "color-depth": "12"}, WithSecurity("nla"))
+	err := config.Validate(args)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("got %v, want *ValidationError", err)
+	}
+	if !reflect.DeepEqual(validationErr.Unknown, []string{"hostnme"}) ||
+		!reflect.DeepEqual(validationErr.Missing, []string{"hostname"}) ||
+		!reflect.DeepEqual(validationErr.Invalid, map[string]string{"color-depth": "12"}) {
+		t.Errorf("unexpected validation error %+v", validationErr)
+	}
+
+	config = NewHandshakeConfig(map[string]string{"hostname": "10.0.0.1"}, WithSecurity("nla"))
+	if err = config.Validate(args); err != nil {
+		t.Errorf("got %v, want nil", err)
+	}
+	if err = NewHandshakeConfig(nil, WithJoinConnection("$abc")).Validate(args); err != nil {
+		t.Errorf("joining should not require hostname, got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package protocol
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ProtocolVersion is the Guacamole protocol version negotiated during the handshake.
+// guacd 1.1.0 and later send it as the first element of args, e.g. VERSION_1_5_0
+type ProtocolVersion struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+var (
+	// Version100 is assumed when args does not start with a version, guacd 1.0.0 and older
+	Version100 = ProtocolVersion{1, 0, 0}
+	Version110 = ProtocolVersion{1, 1, 0}
+	Version130 = ProtocolVersion{1, 3, 0}
+	Version150 = ProtocolVersion{1, 5, 0}
+
+	// LatestVersion is the most recent version this package implements
+	LatestVersion = Version150
+)
+
+// Capability is a protocol feature only available since a certain version
+type Capability int
+
+const (
+	// ArbitraryHandshakeOrder allows the client handshake instructions to be sent in any order
+	ArbitraryHandshakeOrder Capability = iota
+	// ProtocolVersionDetection means args starts with the server version
+	ProtocolVersionDetection
+	// TimezoneHandshake supports the timezone handshake instruction
+	TimezoneHandshake
+	// RequiredArgs supports the required instruction and argv streams
+	RequiredArgs
+	// NameHandshake supports the name handshake instruction
+	NameHandshake
+	// MsgInstruction supports the msg instruction
+	MsgInstruction
+)
+
+func (c Capability) since() ProtocolVersion {
+	switch c {
+	case ArbitraryHandshakeOrder, ProtocolVersionDetection, TimezoneHandshake:
+		return Version110
+	case RequiredArgs:
+		return Version130
+	default:
+		return Version150
+	}
+}
+
+// String returns the version in the format used by args, e.g. VERSION_1_5_0
+func (v ProtocolVersion) String() string {
+	return versionPrefix + strconv.Itoa(v.Major) + "_" + strconv.Itoa(v.Minor) + "_" + strconv.Itoa(v.Patch)
+}
+
+// Compare returns -1, 0 or +1 depending on whether v is older, equal or newer than other
+func (v ProtocolVersion) Compare(other ProtocolVersion) int {
+	for _, d := range [...]int{v.Major - other.Major, v.Minor - other.Minor, v.Patch - other.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+func (v ProtocolVersion) AtLeast(other ProtocolVersion) bool {
+	return v.Compare(other) >= 0
+}
+
+func (v ProtocolVersion) Supports(c Capability) bool {
+	return v.AtLeast(c.since())
+}
+
+// ParseProtocolVersion parses a version element such as VERSION_1_5_0
+func ParseProtocolVersion(s string) (ProtocolVersion, bool) {
+	if !strings.HasPrefix(s, versionPrefix) {
+		return ProtocolVersion{}, false
+	}
+	parts := strings.Split(strings.TrimPrefix(s, versionPrefix), "_")
+	if len(parts) != 3 {
+		return ProtocolVersion{}, false
+	}
+	var numbers [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return ProtocolVersion{}, false
+		}
+		numbers[i] = n
+	}
+	return ProtocolVersion{numbers[0], numbers[1], numbers[2]}, true
+}
+
+// NegotiateVersion returns the version both sides support given the server args:
+// the older of the server version and LatestVersion, Version100 if the server sent no version
+func NegotiateVersion(args []Element) ProtocolVersion {
+	if len(args) == 0 || !strings.HasPrefix(args[0].Value(), versionPrefix) {
+		return Version100
+	}
+	v, ok := ParseProtocolVersion(args[0].Value())
+	if !ok {
+		return Version110
+	}
+	if v.AtLeast(LatestVersion) {
+		return LatestVersion
+	}
+	return v
+}
@@ -0,0 +1,45 @@
+package protocol
+
+import (
+	"testing"
+)
+
+func TestNegotiateVersion(t *testing.T) {
+	cases := map[Instruction]ProtocolVersion{
+		NewInstruction("args", "hostname", "port"):                  Version100,
+		NewInstruction("args", "VERSION_1_3_0", "hostname", "port"): Version130,
+		NewInstruction("args", "VERSION_9_0_0", "hostname"):         LatestVersion,
+	}
+	for args, want := range cases {
+		if got := NegotiateVersion(args.Args()); got != want {
+			t.Errorf("%s: got %s, want %s", args, got, want)
+		}
+	}
+	if !Version130.Supports(RequiredArgs) || Version130.Supports(NameHandshake) || !Version110.Supports(TimezoneHandshake) {
+		t.Error("unexpected capabilities")
+	}
+}
+
+func TestConnectInstructionVersion(t *testing.T) {
+	config := NewHandshakeConfig(map[string]string{"hostname": "10.0.0.1"})
+	args := NewInstruction("args", "VERSION_1_3_0", "hostname", "port")
+	want := NewInstruction("connect", "VERSION_1_3_0", "10.0.0.1", "")
+	if got := config.ConnectInstruction(args.Args()); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestClientInstructionsFeatureGating(t *testing.T) {
+	config := NewHandshakeConfig(nil, WithTimezone("Asia/Shanghai"), WithClientName("alice"))
+	base := config.SizeInstruction() + config.AudioInstruction() + config.VideoInstruction() + config.ImageInstruction()
+	cases := map[ProtocolVersion]Instruction{
+		Version100: base,
+		Version130: base + NewInstruction("timezone", "Asia/Shanghai"),
+		Version150: base + NewInstruction("timezone", "Asia/Shanghai") + NewInstruction("name", "alice"),
+	}
+	for version, want := range cases {
+		if got := config.ClientInstructions(version); got != want {
+			t.Errorf("%s: got %s, want %s", version, got, want)
+		}
+	}
+}
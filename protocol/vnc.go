@@ -0,0 +1,53 @@
+package protocol
+
+// VNCCursor selects whether the mouse cursor is rendered locally or by the VNC server
+type VNCCursor string
+
+const (
+	VNCCursorLocal  VNCCursor = "local"
+	VNCCursorRemote VNCCursor = "remote"
+)
+
+// VNCParameters https://guacamole.apache.org/doc/gug/configuring-guacamole.html#vnc
+type VNCParameters struct {
+	Hostname  string `guac:"hostname"`
+	Port      int    `guac:"port"`
+	Autoretry int    `guac:"autoretry"`
+	Username  string `guac:"username"`
+	Password  string `guac:"password"`
+
+	// Display
+	ColorDepth    ColorDepth `guac:"color-depth"`
+	SwapRedBlue   bool       `guac:"swap-red-blue"`
+	Cursor        VNCCursor  `guac:"cursor"`
+	Encodings     string     `guac:"encodings"`
+	ForceLossless bool       `guac:"force-lossless"`
+	ReadOnly      bool       `guac:"read-only"`
+
+	// VNC repeater
+	DestHost string `guac:"dest-host"`
+	DestPort int    `guac:"dest-port"`
+
+	// Reverse connection
+	ReverseConnect bool `guac:"reverse-connect"`
+	ListenTimeout  int  `guac:"listen-timeout"`
+
+	// Audio through PulseAudio
+	EnableAudio     bool   `guac:"enable-audio"`
+	AudioServername string `guac:"audio-servername"`
+
+	ClipboardEncoding string `guac:"clipboard-encoding"`
+
+	SFTPParameters
+	RecordingParameters
+	ClipboardParameters
+	WakeOnLANParameters
+}
+
+func (p VNCParameters) Protocol() string {
+	return "vnc"
+}
+
+func (p VNCParameters) ConnectArgs() map[string]string {
+	return encodeParameters(p)
+}
@@ -1,7 +1,6 @@
 package recorder
 
 import (
-	"bufio"
 	"compress/gzip"
 	"context"
 	"io"
@@ -9,6 +8,8 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+
+	"github.com/riete/go-guac/protocol"
 )
 
 const defaultBaseDirectory = "records"
@@ -81,6 +82,24 @@ func (f *FileRecorder) Close(connId string) {
 	}
 }
 
+// Flush writes buffered compressed data and syncs the record file of the connection to disk
+func (f *FileRecorder) Flush(connId string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	connId = f.ConnId(connId)
+	if gw, ok := f.writers[connId].(*gzip.Writer); ok {
+		if err := gw.Flush(); err != nil {
+			return err
+		}
+	}
+	for _, c := range f.closers[connId] {
+		if file, ok := c.(*os.File); ok {
+			return file.Sync()
+		}
+	}
+	return nil
+}
+
 func (f *FileRecorder) Record(connId string, data []byte) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -129,18 +148,18 @@ func (f *FileRecorder) Replay(ctx context.Context, connId string) (chan string,
 				_ = c.Close()
 			}
 		}()
-		br := bufio.NewReader(r)
-		var line string
+		decoder := protocol.NewDecoder(r)
+		var instr protocol.Instruction
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			default:
-				line, err = br.ReadString(';')
+				instr, err = decoder.Decode()
 				if err != nil {
 					return
 				}
-				ch <- line
+				ch <- string(instr)
 			}
 		}
 	}()
@@ -9,3 +9,9 @@ type Recorder interface {
 	Replay(ctx context.Context, connId string) (chan string, error)
 	Close(connId string)
 }
+
+// Flusher is implemented by recorders buffering data, Flush writes out what was recorded for the connection so far.
+// Tunnels flush their recorders when forwarding stops
+type Flusher interface {
+	Flush(connId string) error
+}
@@ -0,0 +1,231 @@
+package tunnel
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/riete/go-guac/protocol"
+)
+
+// maxClipboardStreams limits the clipboard streams open at the same time in each direction,
+// guacamole-common-js opens one at a time
+const maxClipboardStreams = 16
+
+// defaultClipboardMaxSize matches the clipboard buffer of guacd, larger contents are truncated by guacd anyway
+const defaultClipboardMaxSize = 256 * 1024
+
+// ClipboardDirection is the direction a clipboard stream travels
+type ClipboardDirection int
+
+const (
+	// ClipboardCopyIn is content copied on the client and pasted into the remote session
+	ClipboardCopyIn ClipboardDirection = iota
+	// ClipboardCopyOut is content copied in the remote session and sent to the client
+	ClipboardCopyOut
+)
+
+func (d ClipboardDirection) String() string {
+	if d == ClipboardCopyIn {
+		return "copy-in"
+	}
+	return "copy-out"
+}
+
+// ClipboardEvent describes a reassembled clipboard stream
+type ClipboardEvent struct {
+	UUID      string
+	ConnId    string
+	Direction ClipboardDirection
+	Mimetype  string
+	// Size is the decoded size in bytes
+	Size int
+	// Exceeded is true if the content was larger than the limit of WithClipboardMaxSize, Data is nil then
+	Exceeded bool
+	// Data is the decoded content, passed to the policy but not kept in audit events
+	Data []byte
+	// Text is the content of text/* streams if enabled in WithClipboardAudit
+	Text    string
+	Allowed bool
+	Time    time.Time
+}
+
+// ClipboardPolicy decides whether a reassembled clipboard stream is passed on
+type ClipboardPolicy interface {
+	AllowClipboard(event *ClipboardEvent) bool
+}
+
+// ClipboardPolicyFunc adapts a function to ClipboardPolicy
+type ClipboardPolicyFunc func(event *ClipboardEvent) bool
+
+func (f ClipboardPolicyFunc) AllowClipboard(event *ClipboardEvent) bool {
+	return f(event)
+}
+
+// BasicClipboardPolicy blocks whole directions
+type BasicClipboardPolicy struct {
+	DisableCopyIn  bool
+	DisableCopyOut bool
+}
+
+func (p BasicClipboardPolicy) AllowClipboard(event *ClipboardEvent) bool {
+	if event.Direction == ClipboardCopyIn {
+		return !p.DisableCopyIn
+	}
+	return !p.DisableCopyOut
+}
+
+// WithClipboardPolicy holds clipboard streams back until they end and passes them on only if policy allows it.
+// Refused copy-in streams are answered with a CLIENT_FORBIDDEN ack
+func WithClipboardPolicy(policy ClipboardPolicy) TunnelOption {
+	return func(t *Tunnel) {
+		t.clipboardFilter().policy = policy
+	}
+}
+
+// WithClipboardMaxSize refuses clipboard streams larger than n bytes, the default is the 256 KiB guacd accepts
+func WithClipboardMaxSize(n int) TunnelOption {
+	return func(t *Tunnel) {
+		if n > 0 {
+			t.clipboardFilter().maxSize = n
+		}
+	}
+}
+
+// WithClipboardAudit calls f for every clipboard stream in either direction after the policy decided.
+// If includeText is true, the content of text/* streams is added to the event
+func WithClipboardAudit(f func(event ClipboardEvent), includeText bool) TunnelOption {
+	return func(t *Tunnel) {
+		filter := t.clipboardFilter()
+		filter.audit = f
+		filter.auditText = includeText
+	}
+}
+
+// clipboardStream is a clipboard stream being reassembled
+type clipboardStream struct {
+	held     protocol.Instruction // clipboard and blob instructions held back until end
+	mimetype string
+	data     []byte
+	size     int
+	exceeded bool
+}
+
+type clipboardFilter struct {
+	tunnel    *Tunnel
+	policy    ClipboardPolicy
+	maxSize   int
+	audit     func(event ClipboardEvent)
+	auditText bool
+}
+
+// clipboardFilter returns the clipboard filter of the tunnel, installing its middlewares on first use
+func (t *Tunnel) clipboardFilter() *clipboardFilter {
+	if t.clipboard == nil {
+		t.clipboard = &clipboardFilter{tunnel: t, maxSize: defaultClipboardMaxSize}
+		t.clientMiddleware = append(t.clientMiddleware, t.clipboard.middleware(ClipboardCopyIn))
+		t.guacdMiddleware = append(t.guacdMiddleware, t.clipboard.middleware(ClipboardCopyOut))
+	}
+	return t.clipboard
+}
+
+// middleware reassembles the clipboard streams of one direction. Each direction runs in its own goroutine,
+// so the streams need no locking. Streams not ended when forwarding stops are dropped
+func (f *clipboardFilter) middleware(direction ClipboardDirection) Middleware {
+	streams := make(map[int]*clipboardStream)
+	f.tunnel.cleanups = append(f.tunnel.cleanups, func() {
+		clear(streams)
+	})
+	return func(ctx context.Context, instr protocol.Instruction) (protocol.Instruction, bool, error) {
+		opcode := instr.Opcode().Value()
+		if opcode != protocol.OpClipboard && opcode != protocol.OpBlob && opcode != protocol.OpEnd {
+			return instr, true, nil
+		}
+		values, err := instr.Values()
+		if err != nil || len(values) < 2 {
+			return instr, true, nil
+		}
+		stream, err := strconv.Atoi(values[1])
+		if err != nil {
+			return instr, true, nil
+		}
+
+		if opcode == protocol.OpClipboard {
+			var clipboard protocol.Clipboard
+			if err = clipboard.Unmarshal(instr); err != nil {
+				return instr, true, nil
+			}
+			if _, reopened := streams[stream]; !reopened && len(streams) >= maxClipboardStreams {
+				if direction == ClipboardCopyIn {
+					ack := protocol.Ack{Stream: stream, Message: "Too many clipboard streams", Status: protocol.ClientTooMany}.Marshal()
+					return "", false, f.tunnel.WriteToClient(ack)
+				}
+				return "", false, nil
+			}
+			streams[stream] = &clipboardStream{held: instr, mimetype: clipboard.Mimetype}
+			return "", false, nil
+		}
+		s, exists := streams[stream]
+		if !exists {
+			return instr, true, nil
+		}
+		if opcode == protocol.OpBlob {
+			f.append(s, instr)
+			return "", false, nil
+		}
+
+		delete(streams, stream)
+		event := &ClipboardEvent{
+			UUID:      f.tunnel.uuid,
+			ConnId:    f.tunnel.ConnId(),
+			Direction: direction,
+			Mimetype:  s.mimetype,
+			Size:      s.size,
+			Exceeded:  s.exceeded,
+			Data:      s.data,
+			Time:      time.Now(),
+		}
+		event.Allowed = !s.exceeded && (f.policy == nil || f.policy.AllowClipboard(event))
+		if f.audit != nil {
+			audited := *event
+			audited.Data = nil
+			if f.auditText && strings.HasPrefix(s.mimetype, "text/") {
+				audited.Text = string(s.data)
+			}
+			f.audit(audited)
+		}
+		if event.Allowed {
+			return s.held + instr, true, nil
+		}
+		if direction == ClipboardCopyIn {
+			ack := protocol.Ack{Stream: stream, Message: "Clipboard refused", Status: protocol.ClientForbidden}.Marshal()
+			return "", false, f.tunnel.WriteToClient(ack)
+		}
+		return "", false, nil
+	}
+}
+
+// append adds the decoded content of blob to s, dropping everything once the limit is exceeded
+func (f *clipboardFilter) append(s *clipboardStream, instr protocol.Instruction) {
+	var blob protocol.Blob
+	if err := blob.Unmarshal(instr); err != nil {
+		return
+	}
+	data, err := blob.Bytes()
+	if err != nil {
+		return
+	}
+	s.size += len(data)
+	if s.exceeded {
+		return
+	}
+	if s.size > f.maxSize {
+		s.exceeded = true
+		s.data = nil
+		s.held = ""
+		return
+	}
+	s.data = append(s.data, data...)
+	s.held += instr
+}
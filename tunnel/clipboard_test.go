@@ -0,0 +1,96 @@
+package tunnel
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/riete/go-guac/protocol"
+)
+
+func TestClipboardPolicy(t *testing.T) {
+	browser, client := NewPipeTransport()
+	var events []ClipboardEvent
+	tun := NewTunnelWithTransport(nil, client,
+		WithClipboardPolicy(BasicClipboardPolicy{DisableCopyOut: true}),
+		WithClipboardMaxSize(8),
+		WithClipboardAudit(func(event ClipboardEvent) { events = append(events, event) }, true),
+	)
+	stream := func(chain middlewareChain, index int, text string) protocol.Instruction {
+		t.Helper()
+		var out protocol.Instruction
+		for _, instr := range []protocol.Instruction{
+			protocol.Clipboard{Stream: index, Mimetype: "text/plain"}.Marshal(),
+			protocol.NewBlob(index, []byte(text[:len(text)/2])).Marshal(),
+			protocol.NewBlob(index, []byte(text[len(text)/2:])).Marshal(),
+			protocol.End{Stream: index}.Marshal(),
+		} {
+			o, err := chain.apply(context.Background(), instr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out += o
+		}
+		return out
+	}
+
+	if out := stream(tun.clientMiddleware, 1, "hello"); !strings.HasPrefix(string(out), string(protocol.Clipboard{Stream: 1, Mimetype: "text/plain"}.Marshal())) {
+		t.Fatalf("copy-in not passed on: %q", out)
+	}
+	if out := stream(tun.guacdMiddleware, 2, "secret"); out != "" {
+		t.Fatalf("copy-out passed although disabled: %q", out)
+	}
+	if out := stream(tun.clientMiddleware, 3, "too long for the limit"); out != "" {
+		t.Fatalf("clipboard over the limit passed: %q", out)
+	}
+	want := protocol.Ack{Stream: 3, Message: "Clipboard refused", Status: protocol.ClientForbidden}.Marshal()
+	if ack, err := browser.ReadInstruction(); err != nil || ack != want {
+		t.Fatalf("got %q %v, want %q", ack, err, want)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("got %d audit events, want 3", len(events))
+	}
+	if e := events[0]; !e.Allowed || e.Direction != ClipboardCopyIn || e.Text != "hello" || e.Size != 5 || e.Data != nil {
+		t.Errorf("unexpected copy-in event %+v", e)
+	}
+	if e := events[1]; e.Allowed || e.Direction != ClipboardCopyOut || e.Text != "secret" {
+		t.Errorf("unexpected copy-out event %+v", e)
+	}
+	if e := events[2]; e.Allowed || !e.Exceeded || e.Size != 22 || e.Text != "" {
+		t.Errorf("unexpected exceeded event %+v", e)
+	}
+}
+
+func TestClipboardStreamLimit(t *testing.T) {
+	browser, client := NewPipeTransport()
+	tun := NewTunnelWithTransport(nil, client, WithClipboardMaxSize(8))
+	open := func(index int) protocol.Instruction {
+		t.Helper()
+		out, err := tun.clientMiddleware.apply(context.Background(), protocol.Clipboard{Stream: index, Mimetype: "text/plain"}.Marshal())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	for i := 0; i < maxClipboardStreams; i++ {
+		if out := open(i); out != "" {
+			t.Fatalf("clipboard stream %d not held back: %q", i, out)
+		}
+	}
+	open(maxClipboardStreams)
+	want := protocol.Ack{Stream: maxClipboardStreams, Message: "Too many clipboard streams", Status: protocol.ClientTooMany}.Marshal()
+	if ack, err := browser.ReadInstruction(); err != nil || ack != want {
+		t.Fatalf("got %q %v, want %q", ack, err, want)
+	}
+
+	// forwarding stopped, the streams which never ended are dropped
+	for _, cleanup := range tun.cleanups {
+		cleanup()
+	}
+	open(maxClipboardStreams)
+	out, err := tun.clientMiddleware.apply(context.Background(), protocol.End{Stream: maxClipboardStreams}.Marshal())
+	if err != nil || out.Opcode().Value() != protocol.OpClipboard {
+		t.Fatalf("got %q %v after cleanup, want the clipboard stream passed on", out, err)
+	}
+}
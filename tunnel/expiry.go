@@ -0,0 +1,122 @@
+package tunnel
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/riete/go-guac/protocol"
+)
+
+// ExpiryReason tells why a session is about to expire
+type ExpiryReason int
+
+const (
+	// ExpiryIdle is the idle timeout of WithIdleTimeout
+	ExpiryIdle ExpiryReason = iota
+	// ExpiryMaxDuration is the maximum session duration of WithMaxDuration
+	ExpiryMaxDuration
+)
+
+func (r ExpiryReason) String() string {
+	if r == ExpiryIdle {
+		return "idle timeout"
+	}
+	return "maximum session duration"
+}
+
+// ExpiryWarning is called before a session expires with the time left, e.g. to show a notice to the user
+// with Tunnel.WriteToClient. It is called at most once per idle period and once for the maximum duration
+type ExpiryWarning func(t *Tunnel, reason ExpiryReason, remaining time.Duration)
+
+// WithIdleTimeout ends the session with protocol.SessionTimeout after d without mouse, key or touch input
+// from the client. It is independent of WithWsKeepalive, which only checks that the client is reachable
+func WithIdleTimeout(d time.Duration) TunnelOption {
+	return func(t *Tunnel) {
+		t.idleTimeout = d
+	}
+}
+
+// WithMaxDuration ends the session with protocol.SessionTimeout d after Forward started, regardless of input
+func WithMaxDuration(d time.Duration) TunnelOption {
+	return func(t *Tunnel) {
+		t.maxDuration = d
+	}
+}
+
+// WithExpiryWarning calls f the given time before the idle timeout or maximum duration expires
+func WithExpiryWarning(before time.Duration, f ExpiryWarning) TunnelOption {
+	return func(t *Tunnel) {
+		t.warnBefore = before
+		t.expiryWarning = f
+	}
+}
+
+// isInput reports whether instr is user input resetting the idle timeout
+func isInput(instr protocol.Instruction) bool {
+	switch instr.Opcode().Value() {
+	case protocol.OpMouse, protocol.OpKey, protocol.OpTouch:
+		return true
+	}
+	return false
+}
+
+// watchExpiry sleeps until the next warning or expiry. Input read by wsToGuacd moves the idle deadline,
+// which is picked up when the timer for the previous deadline fires
+func (t *Tunnel) watchExpiry(ctx context.Context, cancel context.CancelFunc) {
+	started := time.Now()
+	t.lastInput.Store(started.UnixNano())
+	var maxWarned bool
+	var idleWarnedAt int64 // last input when the idle warning was given
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		now := time.Now()
+		next := time.Duration(math.MaxInt64)
+		schedule := func(reason ExpiryReason, deadline time.Time, warned bool) bool {
+			if !now.Before(deadline) {
+				t.expire(cancel, reason)
+				return true
+			}
+			next = min(next, deadline.Sub(now))
+			if t.expiryWarning == nil || warned {
+				return false
+			}
+			if warnAt := deadline.Add(-t.warnBefore); now.Before(warnAt) {
+				next = min(next, warnAt.Sub(now))
+				return false
+			}
+			t.expiryWarning(t, reason, deadline.Sub(now))
+			if reason == ExpiryIdle {
+				idleWarnedAt = t.lastInput.Load()
+			} else {
+				maxWarned = true
+			}
+			return false
+		}
+		if t.maxDuration > 0 && schedule(ExpiryMaxDuration, started.Add(t.maxDuration), maxWarned) {
+			return
+		}
+		if t.idleTimeout > 0 {
+			lastInput := t.lastInput.Load()
+			if schedule(ExpiryIdle, time.Unix(0, lastInput).Add(t.idleTimeout), idleWarnedAt == lastInput) {
+				return
+			}
+		}
+		timer.Reset(next)
+	}
+}
+
+// expire stops forwarding gracefully with protocol.SessionTimeout
+func (t *Tunnel) expire(cancel context.CancelFunc, reason ExpiryReason) {
+	t.mu.Lock()
+	t.stopStatus, t.stopMessage = protocol.SessionTimeout, "Session ended: "+reason.String()
+	t.stopRequested = true
+	t.mu.Unlock()
+	cancel()
+}
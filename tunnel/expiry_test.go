@@ -0,0 +1,85 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/riete/go-guac/protocol"
+)
+
+func forwardExpiring(t *testing.T, opts ...TunnelOption) (ClientTransport, chan error) {
+	t.Helper()
+	guacdClient, guacdServer := net.Pipe()
+	fakeGuacd(t, guacdServer, func(d *protocol.Decoder) bool {
+		return acceptHandshake(guacdServer, d, protocol.NewInstruction("ready", "$conn"))
+	})
+	browser, client := NewPipeTransport()
+	tun := NewTunnelWithTransport(guacdClient, client, append(opts, WithGracePeriod(10*time.Millisecond))...)
+	t.Cleanup(tun.Close)
+	if err := tun.Handshake(protocol.NewHandshakeConfig(nil)); err != nil {
+		t.Fatal(err)
+	}
+	forwarded := make(chan error, 1)
+	go func() { forwarded <- tun.Forward(context.Background()) }()
+	_, _ = browser.ReadInstruction() // tunnel uuid
+	return browser, forwarded
+}
+
+func waitSessionTimeout(t *testing.T, browser ClientTransport, forwarded chan error) {
+	t.Helper()
+	if instr, err := browser.ReadInstruction(); err != nil || instr.Error() == nil {
+		t.Fatalf("got %q %v, want error instruction", instr, err)
+	}
+	select {
+	case err := <-forwarded:
+		var guacErr *protocol.Error
+		if !errors.As(err, &guacErr) || guacErr.Status != protocol.SessionTimeout {
+			t.Fatalf("Forward returned %v, want session timeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("session did not expire")
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	warned := make(chan ExpiryReason, 1)
+	start := time.Now()
+	browser, forwarded := forwardExpiring(t,
+		WithIdleTimeout(200*time.Millisecond),
+		WithExpiryWarning(100*time.Millisecond, func(t *Tunnel, reason ExpiryReason, remaining time.Duration) {
+			warned <- reason
+		}),
+	)
+	// input keeps the session alive past the first idle deadline
+	time.Sleep(150 * time.Millisecond)
+	_ = browser.WriteInstruction(protocol.Mouse{X: 1, Y: 1}.Marshal())
+
+	select {
+	case reason := <-warned:
+		if reason != ExpiryIdle {
+			t.Fatalf("got warning for %s, want idle timeout", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no expiry warning")
+	}
+	waitSessionTimeout(t, browser, forwarded)
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+		t.Fatalf("expired after %s, input did not reset the idle timeout", elapsed)
+	}
+}
+
+func TestMaxDuration(t *testing.T) {
+	browser, forwarded := forwardExpiring(t, WithMaxDuration(50*time.Millisecond))
+	go func() {
+		for {
+			if browser.WriteInstruction(protocol.Key{Keysym: 65, Pressed: true}.Marshal()) != nil {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	waitSessionTimeout(t, browser, forwarded)
+}
@@ -0,0 +1,320 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/riete/go-guac/protocol"
+)
+
+// streamIndexMimetype is the mimetype of directory listings sent in body instructions, they are not file transfers
+const streamIndexMimetype = "application/vnd.glyptodon.guacamole.stream-index+json"
+
+// FileDirection is the direction of a file transfer
+type FileDirection int
+
+const (
+	// FileAnyDirection matches both directions in a FileRule
+	FileAnyDirection FileDirection = iota
+	// FileUpload is a file sent by the client to the remote session, opened by file or put
+	FileUpload
+	// FileDownload is a file sent by the remote session to the client, opened by file or body
+	FileDownload
+)
+
+func (d FileDirection) String() string {
+	switch d {
+	case FileUpload:
+		return "upload"
+	case FileDownload:
+		return "download"
+	}
+	return "any"
+}
+
+// FileTransfer describes a file transfer stream. The policy sees it when the stream is opened,
+// the audit function once it ended, was refused or aborted
+type FileTransfer struct {
+	UUID      string
+	ConnId    string
+	Direction FileDirection
+	Stream    int
+	Filename  string
+	Mimetype  string
+	// Size is the number of decoded bytes transferred
+	Size      int64
+	Allowed   bool
+	Completed bool
+	// Reason explains why a transfer was refused or aborted
+	Reason  string
+	Started time.Time
+	Ended   time.Time
+}
+
+// FilePolicy decides when a transfer is opened whether it may proceed and up to how many bytes, 0 for no limit
+type FilePolicy interface {
+	AllowFile(transfer *FileTransfer) (allow bool, maxSize int64)
+}
+
+// FileRule matches transfers by direction and filename. Pattern is a path.Match pattern for the base name
+// of the file, matched case-insensitively, e.g. "*.exe". An empty pattern matches every file
+type FileRule struct {
+	Direction FileDirection
+	Pattern   string
+	Deny      bool
+	MaxSize   int64
+}
+
+func (r FileRule) matches(transfer *FileTransfer) bool {
+	if r.Direction != FileAnyDirection && r.Direction != transfer.Direction {
+		return false
+	}
+	if r.Pattern == "" {
+		return true
+	}
+	name := path.Base(strings.ReplaceAll(transfer.Filename, "\\", "/"))
+	matched, _ := path.Match(strings.ToLower(r.Pattern), strings.ToLower(name))
+	return matched
+}
+
+// FileRules is a FilePolicy where the first matching rule decides.
+// Transfers matching no rule are allowed without limit unless DenyByDefault is set
+type FileRules struct {
+	Rules         []FileRule
+	DenyByDefault bool
+}
+
+func (r FileRules) AllowFile(transfer *FileTransfer) (bool, int64) {
+	for _, rule := range r.Rules {
+		if rule.matches(transfer) {
+			return !rule.Deny, rule.MaxSize
+		}
+	}
+	return !r.DenyByDefault, 0
+}
+
+// FileSink stores copies of transferred files, e.g. for DLP review.
+// Create is called for every allowed transfer, the writer receives the decoded content and is closed when the transfer
+// ends or is aborted, at the latest when forwarding stops. If Create fails the transfer is refused
+type FileSink interface {
+	Create(transfer FileTransfer) (io.WriteCloser, error)
+}
+
+// WithFilePolicy enforces policy on file transfers. Refused uploads are answered with a CLIENT_FORBIDDEN ack,
+// uploads exceeding their limit with CLIENT_OVERRUN and an end sent to guacd, so the remote file is left incomplete.
+// Refused and oversized downloads are aborted by acking guacd with the same statuses, oversized ones are ended
+// toward the client as well
+func WithFilePolicy(policy FilePolicy) TunnelOption {
+	return func(t *Tunnel) {
+		t.fileFilter().policy = policy
+	}
+}
+
+// WithFileSink stores a copy of every allowed file transfer in sink
+func WithFileSink(sink FileSink) TunnelOption {
+	return func(t *Tunnel) {
+		t.fileFilter().sink = sink
+	}
+}
+
+// WithFileAudit calls f once for every file transfer when it ended, was refused or aborted,
+// including transfers still open when forwarding stops
+func WithFileAudit(f func(transfer FileTransfer)) TunnelOption {
+	return func(t *Tunnel) {
+		t.fileFilter().audit = f
+	}
+}
+
+type fileStream struct {
+	transfer FileTransfer
+	maxSize  int64
+	copy     io.WriteCloser
+	aborted  bool
+}
+
+type fileFilter struct {
+	tunnel *Tunnel
+	policy FilePolicy
+	sink   FileSink
+	audit  func(transfer FileTransfer)
+}
+
+// fileFilter returns the file transfer filter of the tunnel, installing its middlewares on first use
+func (t *Tunnel) fileFilter() *fileFilter {
+	if t.files == nil {
+		t.files = &fileFilter{tunnel: t}
+		t.clientMiddleware = append(t.clientMiddleware, t.files.middleware(FileUpload))
+		t.guacdMiddleware = append(t.guacdMiddleware, t.files.middleware(FileDownload))
+	}
+	return t.files
+}
+
+// open builds the transfer of a stream opening instruction, false if instr opens no file transfer
+func (f *fileFilter) open(direction FileDirection, instr protocol.Instruction) (*FileTransfer, bool) {
+	transfer := &FileTransfer{
+		UUID:      f.tunnel.uuid,
+		ConnId:    f.tunnel.ConnId(),
+		Direction: direction,
+		Started:   time.Now(),
+	}
+	switch opcode := instr.Opcode().Value(); {
+	case opcode == protocol.OpFile:
+		var file protocol.File
+		if file.Unmarshal(instr) != nil {
+			return nil, false
+		}
+		transfer.Stream, transfer.Mimetype, transfer.Filename = file.Stream, file.Mimetype, file.Filename
+	case opcode == protocol.OpPut && direction == FileUpload:
+		var put protocol.Put
+		if put.Unmarshal(instr) != nil {
+			return nil, false
+		}
+		transfer.Stream, transfer.Mimetype, transfer.Filename = put.Stream, put.Mimetype, put.Name
+	case opcode == protocol.OpBody && direction == FileDownload:
+		var body protocol.Body
+		if body.Unmarshal(instr) != nil || body.Mimetype == streamIndexMimetype {
+			return nil, false
+		}
+		transfer.Stream, transfer.Mimetype, transfer.Filename = body.Stream, body.Mimetype, body.Name
+	default:
+		return nil, false
+	}
+	return transfer, true
+}
+
+// refuse answers the sender of a stream that will not be passed on
+func (f *fileFilter) refuse(direction FileDirection, stream int, message string, status protocol.StatusCode) error {
+	ack := protocol.Ack{Stream: stream, Message: message, Status: status}.Marshal()
+	if direction == FileUpload {
+		return f.tunnel.WriteToClient(ack)
+	}
+	return f.tunnel.WriteToGuacd(ack)
+}
+
+func (f *fileFilter) finish(s *fileStream) {
+	s.transfer.Ended = time.Now()
+	if s.copy != nil {
+		_ = s.copy.Close()
+		s.copy = nil
+	}
+	if f.audit != nil {
+		f.audit(s.transfer)
+	}
+}
+
+// abort ends the transfer of s. guacd frees a download stream once it is acked with an error,
+// an aborted upload is kept until its end to drop the blobs the client may still send
+func (f *fileFilter) abort(streams map[int]*fileStream, s *fileStream) {
+	s.aborted = true
+	f.finish(s)
+	if s.transfer.Direction == FileDownload {
+		delete(streams, s.transfer.Stream)
+	}
+}
+
+// middleware tracks the file transfers of one direction. Openers, blobs and end of a transfer all come from the same
+// side, so each direction is handled by one goroutine and needs no locking.
+// Transfers still open when forwarding stops are aborted
+func (f *fileFilter) middleware(direction FileDirection) Middleware {
+	streams := make(map[int]*fileStream)
+	f.tunnel.cleanups = append(f.tunnel.cleanups, func() {
+		for index, s := range streams {
+			delete(streams, index)
+			if !s.aborted {
+				s.transfer.Reason = "tunnel closed"
+				f.abort(streams, s)
+			}
+		}
+	})
+	return func(ctx context.Context, instr protocol.Instruction) (protocol.Instruction, bool, error) {
+		switch instr.Opcode().Value() {
+		case protocol.OpFile, protocol.OpPut, protocol.OpBody:
+			transfer, ok := f.open(direction, instr)
+			if !ok {
+				return instr, true, nil
+			}
+			s := &fileStream{transfer: *transfer}
+			streams[transfer.Stream] = s
+			allow := true
+			if f.policy != nil {
+				allow, s.maxSize = f.policy.AllowFile(&s.transfer)
+			}
+			if allow && f.sink != nil {
+				w, err := f.sink.Create(s.transfer)
+				if err != nil {
+					allow = false
+					s.transfer.Reason = "capture failed: " + err.Error()
+				}
+				s.copy = w
+			}
+			s.transfer.Allowed = allow
+			if allow {
+				return instr, true, nil
+			}
+			if s.transfer.Reason == "" {
+				s.transfer.Reason = "refused by policy"
+			}
+			f.abort(streams, s)
+			return "", false, f.refuse(direction, s.transfer.Stream, "File transfer refused", protocol.ClientForbidden)
+
+		case protocol.OpBlob:
+			var blob protocol.Blob
+			if blob.Unmarshal(instr) != nil {
+				return instr, true, nil
+			}
+			s, exists := streams[blob.Stream]
+			if !exists {
+				return instr, true, nil
+			}
+			if s.aborted {
+				return "", false, nil
+			}
+			data, err := blob.Bytes()
+			if err != nil {
+				return instr, true, nil
+			}
+			s.transfer.Size += int64(len(data))
+			if s.maxSize > 0 && s.transfer.Size > s.maxSize {
+				s.transfer.Reason = "size limit exceeded"
+				f.abort(streams, s)
+				if err = f.refuse(direction, blob.Stream, "File too large", protocol.ClientOverrun); err != nil {
+					return "", false, err
+				}
+				// the other side already received part of the file, end the stream there too
+				end := protocol.End{Stream: blob.Stream}.Marshal()
+				if direction == FileUpload {
+					return "", false, f.tunnel.WriteToGuacd(end)
+				}
+				return "", false, f.tunnel.WriteToClient(end)
+			}
+			if s.copy != nil {
+				if _, err = s.copy.Write(data); err != nil {
+					_ = s.copy.Close()
+					s.copy = nil
+				}
+			}
+			return instr, true, nil
+
+		case protocol.OpEnd:
+			var end protocol.End
+			if end.Unmarshal(instr) != nil {
+				return instr, true, nil
+			}
+			s, exists := streams[end.Stream]
+			if !exists {
+				return instr, true, nil
+			}
+			delete(streams, end.Stream)
+			if s.aborted {
+				return "", false, nil
+			}
+			s.transfer.Completed = true
+			f.finish(s)
+			return instr, true, nil
+		}
+		return instr, true, nil
+	}
+}
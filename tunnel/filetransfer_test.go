@@ -0,0 +1,165 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/riete/go-guac/protocol"
+)
+
+type bufferSink struct {
+	files map[string]*bytes.Buffer
+}
+
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func (s *bufferSink) Create(transfer FileTransfer) (io.WriteCloser, error) {
+	b := &bytes.Buffer{}
+	s.files[transfer.Filename] = b
+	return nopCloser{b}, nil
+}
+
+func TestFilePolicy(t *testing.T) {
+	browser, client := NewPipeTransport()
+	guacdClient, guacdServer := net.Pipe()
+	fromTunnel := make(chan protocol.Instruction, 4)
+	go func() {
+		d := protocol.NewDecoder(guacdServer)
+		for {
+			instr, err := d.Decode()
+			if err != nil {
+				return
+			}
+			fromTunnel <- instr
+		}
+	}()
+	defer guacdClient.Close()
+
+	sink := &bufferSink{files: make(map[string]*bytes.Buffer)}
+	var audited []FileTransfer
+	tun := NewTunnelWithTransport(guacdClient, client,
+		WithFilePolicy(FileRules{Rules: []FileRule{
+			{Direction: FileUpload, Pattern: "*.EXE", Deny: true},
+			{Direction: FileDownload, MaxSize: 4},
+		}}),
+		WithFileSink(sink),
+		WithFileAudit(func(transfer FileTransfer) { audited = append(audited, transfer) }),
+	)
+	apply := func(chain middlewareChain, instr protocol.Instruction) protocol.Instruction {
+		t.Helper()
+		out, err := chain.apply(context.Background(), instr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	// allowed upload is passed on and captured
+	for _, instr := range []protocol.Instruction{
+		protocol.File{Stream: 1, Mimetype: "text/plain", Filename: "notes.txt"}.Marshal(),
+		protocol.NewBlob(1, []byte("hello")).Marshal(),
+		protocol.End{Stream: 1}.Marshal(),
+	} {
+		if apply(tun.clientMiddleware, instr) != instr {
+			t.Fatalf("allowed upload instruction %q dropped", instr)
+		}
+	}
+	if sink.files["notes.txt"].String() != "hello" {
+		t.Fatalf("captured %q, want hello", sink.files["notes.txt"])
+	}
+
+	// refused upload is answered with an ack
+	if apply(tun.clientMiddleware, protocol.Put{Object: 1, Stream: 2, Mimetype: "application/octet-stream", Name: "/tmp/setup.exe"}.Marshal()) != "" {
+		t.Fatal("refused upload passed on")
+	}
+	want := protocol.Ack{Stream: 2, Message: "File transfer refused", Status: protocol.ClientForbidden}.Marshal()
+	if ack, err := browser.ReadInstruction(); err != nil || ack != want {
+		t.Fatalf("got %q %v, want %q", ack, err, want)
+	}
+
+	// oversized download is aborted towards guacd and ended towards the client
+	apply(tun.guacdMiddleware, protocol.Body{Object: 1, Stream: 3, Mimetype: "application/octet-stream", Name: "/big.bin"}.Marshal())
+	if apply(tun.guacdMiddleware, protocol.NewBlob(3, []byte("too large")).Marshal()) != "" {
+		t.Fatal("oversized download blob passed on")
+	}
+	want = protocol.Ack{Stream: 3, Message: "File too large", Status: protocol.ClientOverrun}.Marshal()
+	if ack := <-fromTunnel; ack != want {
+		t.Fatalf("guacd got %q, want %q", ack, want)
+	}
+	if end, err := browser.ReadInstruction(); err != nil || end != (protocol.End{Stream: 3}.Marshal()) {
+		t.Fatalf("client got %q %v, want end", end, err)
+	}
+
+	if len(audited) != 3 || !audited[0].Completed || audited[0].Size != 5 || audited[1].Allowed || audited[2].Reason != "size limit exceeded" {
+		t.Fatalf("unexpected audit events %+v", audited)
+	}
+}
+
+type closeSink struct {
+	closed chan string
+}
+
+type closeWriter struct {
+	io.Writer
+	name   string
+	closed chan string
+}
+
+func (w closeWriter) Close() error {
+	w.closed <- w.name
+	return nil
+}
+
+func (s closeSink) Create(transfer FileTransfer) (io.WriteCloser, error) {
+	return closeWriter{Writer: io.Discard, name: transfer.Filename, closed: s.closed}, nil
+}
+
+func TestFileTransferAbortedOnStop(t *testing.T) {
+	guacdClient, guacdServer := net.Pipe()
+	fakeGuacd(t, guacdServer, func(d *protocol.Decoder) bool {
+		return acceptHandshake(guacdServer, d, protocol.NewInstruction("ready", "$conn"))
+	})
+	sink := closeSink{closed: make(chan string, 1)}
+	var audited []FileTransfer
+	browser, client := NewPipeTransport()
+	tun := NewTunnelWithTransport(guacdClient, client, WithFileSink(sink),
+		WithFileAudit(func(transfer FileTransfer) { audited = append(audited, transfer) }))
+	t.Cleanup(tun.Close)
+	if err := tun.Handshake(protocol.NewHandshakeConfig(nil)); err != nil {
+		t.Fatal(err)
+	}
+	forwarded := make(chan error, 1)
+	go func() { forwarded <- tun.Forward(context.Background()) }()
+	_, _ = browser.ReadInstruction() // tunnel uuid
+
+	// the browser goes away in the middle of an upload
+	_ = browser.WriteInstruction(protocol.File{Stream: 1, Mimetype: "text/plain", Filename: "notes.txt"}.Marshal())
+	_ = browser.WriteInstruction(protocol.NewBlob(1, []byte("hel")).Marshal())
+	_ = browser.Close()
+	select {
+	case <-forwarded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Forward did not return")
+	}
+	select {
+	case name := <-sink.closed:
+		if name != "notes.txt" {
+			t.Fatalf("closed capture of %q, want notes.txt", name)
+		}
+	default:
+		t.Fatal("capture of the open upload not closed")
+	}
+	if len(audited) != 1 || audited[0].Completed || audited[0].Size != 3 || audited[0].Reason != "tunnel closed" {
+		t.Fatalf("unexpected audit events %+v", audited)
+	}
+}
@@ -0,0 +1,172 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/riete/go-guac/protocol"
+)
+
+// guacamoleSubprotocol is the WebSocket subprotocol requested by Guacamole.WebSocketTunnel
+const guacamoleSubprotocol = "guacamole"
+
+const defaultHandlerHandshakeTimeout = 15 * time.Second
+
+// defaultDpi is used when the browser reports a size without dpi
+const defaultDpi = 96
+
+// ConnectionResolver decides which guacd a request connects to and with which configuration.
+// config must not be nil when err is nil. Return a *protocol.Error to control the status reported to the browser
+type ConnectionResolver func(r *http.Request) (guacdAddr string, config *protocol.HandshakeConfig, err error)
+
+type HandlerOption func(*Handler)
+
+// WithUpgrader replaces the default WebSocket upgrader, e.g. to check the origin. The guacamole subprotocol is added to it
+func WithUpgrader(u *websocket.Upgrader) HandlerOption {
+	return func(h *Handler) {
+		h.upgrader = u
+	}
+}
+
+// WithTunnelOptions applies opts to every tunnel created by the handler
+func WithTunnelOptions(opts ...TunnelOption) HandlerOption {
+	return func(h *Handler) {
+		h.tunnelOpts = append(h.tunnelOpts, opts...)
+	}
+}
+
+// WithHandshakeTimeout limits dialing guacd and the handshake together
+func WithHandshakeTimeout(d time.Duration) HandlerOption {
+	return func(h *Handler) {
+		if d > 0 {
+			h.handshakeTimeout = d
+		}
+	}
+}
+
+// Handler upgrades the request to a WebSocket, dials the guacd returned by the resolver,
+// performs the handshake and forwards until either side goes away
+type Handler struct {
+	resolver         ConnectionResolver
+	upgrader         *websocket.Upgrader
+	tunnelOpts       []TunnelOption
+	handshakeTimeout time.Duration
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already replied with an HTTP error
+		return
+	}
+	defer ws.Close()
+
+	guacdAddr, config, err := h.resolver(r)
+	if err != nil {
+		sendWebsocketError(ws, err)
+		return
+	}
+	config.Apply(HandshakeOptionsFromQuery(r.URL.Query())...)
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.handshakeTimeout)
+	defer cancel()
+	var dialer net.Dialer
+	guacd, err := dialer.DialContext(ctx, "tcp", guacdAddr)
+	if err != nil {
+		sendWebsocketError(ws, protocol.NewError(protocol.UpstreamNotFound, "Unable to connect to guacd"))
+		return
+	}
+	t := NewTunnel(guacd, ws, h.tunnelOpts...)
+	defer t.Close()
+	if err = t.HandshakeContext(ctx, config); err != nil {
+		sendWebsocketError(ws, err)
+		return
+	}
+	_ = t.Forward(context.Background())
+}
+
+// sendWebsocketError reports err to the browser as an error instruction followed by the matching close code.
+// The close reason is the numeric status, which guacamole-common-js parses, the message is in the error instruction
+func sendWebsocketError(ws *websocket.Conn, err error) {
+	var guacErr *protocol.Error
+	if !errors.As(err, &guacErr) {
+		guacErr = protocol.NewError(protocol.ServerError, err.Error())
+	}
+	_ = ws.SetWriteDeadline(time.Now().Add(time.Second))
+	_ = ws.WriteMessage(websocket.TextMessage, guacErr.Marshal().Byte())
+	closeMessage := websocket.FormatCloseMessage(guacErr.Status.WebSocketCloseCode(), strconv.Itoa(int(guacErr.Status)))
+	_ = ws.WriteMessage(websocket.CloseMessage, closeMessage)
+}
+
+// queryValue returns the first non empty value of the given keys
+func queryValue(query url.Values, keys ...string) string {
+	for _, key := range keys {
+		if v := query.Get(key); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// queryValues returns all values of the first present key
+func queryValues(query url.Values, keys ...string) []string {
+	for _, key := range keys {
+		if v, ok := query[key]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+// HandshakeOptionsFromQuery converts the client parameters in the connect data of guacamole-common-js,
+// GUAC_WIDTH, GUAC_HEIGHT, GUAC_DPI, GUAC_AUDIO, GUAC_VIDEO, GUAC_IMAGE and GUAC_TIMEZONE,
+// or their lower case names without prefix, into handshake options. Absent parameters keep the configured values
+func HandshakeOptionsFromQuery(query url.Values) []protocol.HandshakeOption {
+	var opts []protocol.HandshakeOption
+	width, errWidth := strconv.Atoi(queryValue(query, "GUAC_WIDTH", "width"))
+	height, errHeight := strconv.Atoi(queryValue(query, "GUAC_HEIGHT", "height"))
+	if errWidth == nil && errHeight == nil && width > 0 && height > 0 {
+		dpi, err := strconv.Atoi(queryValue(query, "GUAC_DPI", "dpi"))
+		if err != nil || dpi <= 0 {
+			dpi = defaultDpi
+		}
+		opts = append(opts, protocol.WithScreen(width, height, dpi))
+	}
+	if codecs := queryValues(query, "GUAC_AUDIO", "audio"); codecs != nil {
+		opts = append(opts, protocol.WithAudioCodecs(codecs))
+	}
+	if codecs := queryValues(query, "GUAC_VIDEO", "video"); codecs != nil {
+		opts = append(opts, protocol.WithVideoCodecs(codecs))
+	}
+	if formats := queryValues(query, "GUAC_IMAGE", "image"); formats != nil {
+		opts = append(opts, protocol.WithImageFormats(formats))
+	}
+	if timezone := queryValue(query, "GUAC_TIMEZONE", "timezone"); timezone != "" {
+		opts = append(opts, protocol.WithTimezone(timezone))
+	}
+	return opts
+}
+
+func NewHandler(resolver ConnectionResolver, opts ...HandlerOption) *Handler {
+	h := &Handler{
+		resolver:         resolver,
+		upgrader:         &websocket.Upgrader{},
+		handshakeTimeout: defaultHandlerHandshakeTimeout,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if !slices.Contains(h.upgrader.Subprotocols, guacamoleSubprotocol) {
+		upgrader := *h.upgrader
+		upgrader.Subprotocols = append(slices.Clone(upgrader.Subprotocols), guacamoleSubprotocol)
+		h.upgrader = &upgrader
+	}
+	return h
+}
@@ -0,0 +1,103 @@
+package tunnel
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/riete/go-guac/protocol"
+)
+
+func dialHandler(t *testing.T, h *Handler, query string) *websocket.Conn {
+	t.Helper()
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+	dialer := websocket.Dialer{Subprotocols: []string{"guacamole"}}
+	browser, resp, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = browser.Close() })
+	if resp.Header.Get("Sec-WebSocket-Protocol") != "guacamole" {
+		t.Fatalf("subprotocol not negotiated")
+	}
+	return browser
+}
+
+func TestHandler(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	sizes := make(chan protocol.Instruction, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		fakeGuacd(t, conn, func(d *protocol.Decoder) bool {
+			if _, err := d.Decode(); err != nil {
+				return false
+			}
+			_, _ = conn.Write(protocol.NewInstruction("args", "VERSION_1_5_0", "hostname").Byte())
+			for {
+				instr, err := d.Decode()
+				if err != nil {
+					return false
+				}
+				switch instr.Opcode().Value() {
+				case protocol.OpSize:
+					sizes <- instr
+				case protocol.OpConnect:
+					_, err = conn.Write((protocol.NewInstruction("ready", "$conn") + protocol.NewInstruction("sync", "1")).Byte())
+					return err == nil
+				}
+			}
+		})
+	}()
+
+	h := NewHandler(func(r *http.Request) (string, *protocol.HandshakeConfig, error) {
+		return listener.Addr().String(), protocol.NewHandshakeConfig(nil, protocol.WithHostPort("host", "3389")), nil
+	})
+	browser := dialHandler(t, h, "GUAC_WIDTH=800&GUAC_HEIGHT=600&GUAC_DPI=120&GUAC_AUDIO=audio/L16")
+	_ = browser.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err = browser.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	_, data, err := browser.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(protocol.NewInstruction("sync", "1")) {
+		t.Fatalf("got %q, want sync", data)
+	}
+	if size := <-sizes; size != protocol.NewInstruction("size", "800", "600", "120") {
+		t.Fatalf("got %q, want the browser size", size)
+	}
+}
+
+func TestHandlerResolverError(t *testing.T) {
+	h := NewHandler(func(r *http.Request) (string, *protocol.HandshakeConfig, error) {
+		return "", nil, protocol.NewError(protocol.ClientForbidden, "denied")
+	})
+	browser := dialHandler(t, h, "")
+	_ = browser.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := browser.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(protocol.NewError(protocol.ClientForbidden, "denied").Marshal()) {
+		t.Fatalf("got %q, want error instruction", data)
+	}
+	_, _, err = browser.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != protocol.ClientForbidden.WebSocketCloseCode() || closeErr.Text != "771" {
+		t.Fatalf("got %v, want close code %d with reason 771", err, protocol.ClientForbidden.WebSocketCloseCode())
+	}
+}
@@ -0,0 +1,176 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/riete/go-guac/protocol"
+)
+
+// HandshakePhase is the step of the handshake an error occurred in
+type HandshakePhase string
+
+const (
+	// PhaseSelect sends select
+	PhaseSelect HandshakePhase = "select"
+	// PhaseArgs waits for args
+	PhaseArgs HandshakePhase = "args"
+	// PhaseConnect validates the arguments and sends the client instructions and connect
+	PhaseConnect HandshakePhase = "connect"
+	// PhaseReady waits for ready, answering required on the way
+	PhaseReady HandshakePhase = "ready"
+)
+
+// ErrPrematureEOF is returned when guacd closes the connection before the handshake completes
+var ErrPrematureEOF = errors.New("guacd closed the connection before the handshake completed")
+
+// HandshakeError reports the phase in which the handshake failed. Err is a *protocol.Error for error
+// instructions from guacd, context.DeadlineExceeded or context.Canceled if the context ended
+type HandshakeError struct {
+	Phase HandshakePhase
+	Err   error
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("handshake %s error: %s", e.Phase, e.Err.Error())
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// Handshake performs the complete handshake process.
+// The handshake flow is:
+//  1. Client sends "select" with the protocol name (vnc, rdp, ssh) or the ID of the connection to join
+//  2. Server responds with "args" listing required parameters
+//  3. Client sends "size" with display dimensions
+//  4. Client sends "audio" with supported audio MIME types
+//  5. Client sends "video" with supported video MIME types
+//  6. Client sends "image" with supported image MIME types
+//  7. Client sends "timezone" and "name" if configured and supported by the protocol version
+//  8. Client sends "connect" with parameter values (in order from args)
+//  9. Server responds with "ready" containing the connection ID,
+//     possibly preceded by "required" if parameters such as credentials are missing
+func (t *Tunnel) Handshake(config *protocol.HandshakeConfig) error {
+	return t.HandshakeContext(context.Background(), config)
+}
+
+// HandshakeContext performs the handshake like Handshake, aborting it once ctx is done.
+// The deadline of ctx is applied to the guacd connection and removed again when the handshake returns
+func (t *Tunnel) HandshakeContext(ctx context.Context, config *protocol.HandshakeConfig) error {
+	started := time.Now()
+	err := t.handshake(ctx, config)
+	latency := time.Since(started)
+	if err == nil {
+		t.handshakeLatency.Store(int64(latency))
+	}
+	if t.metrics != nil {
+		t.metrics.Handshake(t, latency, err)
+	}
+	return err
+}
+
+func (t *Tunnel) handshake(ctx context.Context, config *protocol.HandshakeConfig) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = t.guacd.SetDeadline(deadline)
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			// unblock pending reads and writes
+			_ = t.guacd.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	defer func() {
+		close(done)
+		<-stopped
+		_ = t.guacd.SetDeadline(time.Time{})
+	}()
+
+	phase := PhaseSelect
+	fail := func(err error) error {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		} else if errors.Is(err, os.ErrDeadlineExceeded) {
+			err = context.DeadlineExceeded
+		}
+		return &HandshakeError{Phase: phase, Err: err}
+	}
+	read := func(what string) (protocol.Instruction, error) {
+		instr, err := t.decoder.Decode()
+		if err == io.EOF {
+			return "", ErrPrematureEOF
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// closed in the middle of an instruction, the DecodeError keeps the offset
+			return "", fmt.Errorf("read %s instruction error: %w: %w", what, ErrPrematureEOF, err)
+		}
+		if err != nil {
+			return "", fmt.Errorf("read %s instruction error: %w", what, err)
+		}
+		return instr, instr.Error()
+	}
+
+	t.joined = config.IsJoin()
+	t.protocol = config.Protocol()
+	t.hostname = config.ConnectArg("hostname")
+	if err := t.writeGuacd(config.SelectInstruction().Byte()); err != nil {
+		return fail(fmt.Errorf("send select instruction error: %s", err.Error()))
+	}
+
+	phase = PhaseArgs
+	argsInstr, err := read("args")
+	if err != nil {
+		return fail(err)
+	}
+	if opcode := argsInstr.Opcode().Value(); opcode != protocol.OpArgs {
+		return fail(fmt.Errorf("unexpected instruction %q, want args", opcode))
+	}
+	args := argsInstr.Args()
+	t.protocolVersion = protocol.NegotiateVersion(args)
+
+	phase = PhaseConnect
+	if config.ValidationEnabled() {
+		if err = config.Validate(args); err != nil {
+			return fail(err)
+		}
+	}
+	fullConnectInstr := config.ClientInstructions(t.protocolVersion) + config.ConnectInstruction(args)
+	if err = t.writeGuacd(fullConnectInstr.Byte()); err != nil {
+		return fail(fmt.Errorf("send full connect instruction error: %s", err.Error()))
+	}
+
+	phase = PhaseReady
+	var ready protocol.Ready
+	for {
+		instr, err := read("ready")
+		if err != nil {
+			return fail(err)
+		}
+		if instr.Opcode().Value() == protocol.OpRequired {
+			if err = t.handleRequired(instr, config); err != nil {
+				return fail(err)
+			}
+			continue
+		}
+		if err = ready.Unmarshal(instr); err != nil {
+			return fail(fmt.Errorf("no connection ID received: %s", err.Error()))
+		}
+		break
+	}
+	t.mu.Lock()
+	t.connId = ready.ConnectionID
+	t.mu.Unlock()
+	if t.onConnect != nil {
+		t.onConnect(ready.ConnectionID)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/riete/go-guac/protocol"
+)
+
+// fakeGuacd plays guacd on the server end of a pipe, draining it after respond returns true
+func fakeGuacd(t *testing.T, conn net.Conn, respond func(d *protocol.Decoder) bool) {
+	t.Helper()
+	go func() {
+		defer conn.Close()
+		d := protocol.NewDecoder(conn)
+		if !respond(d) {
+			return
+		}
+		// drain until the client goes away or disconnects
+		for {
+			instr, err := d.Decode()
+			if err != nil || instr == protocol.Disconnect {
+				return
+			}
+		}
+	}()
+}
+
+// acceptHandshake answers select with args and connect with the given response
+func acceptHandshake(conn net.Conn, d *protocol.Decoder, response protocol.Instruction) bool {
+	if _, err := d.Decode(); err != nil {
+		return false
+	}
+	_, _ = conn.Write(protocol.NewInstruction("args", "VERSION_1_5_0", "hostname").Byte())
+	for {
+		instr, err := d.Decode()
+		if err != nil {
+			return false
+		}
+		if instr.Opcode().Value() == protocol.OpConnect {
+			break
+		}
+	}
+	_, err := conn.Write(response.Byte())
+	return err == nil
+}
+
+func TestHandshakeContext(t *testing.T) {
+	client, server := net.Pipe()
+	fakeGuacd(t, server, func(d *protocol.Decoder) bool {
+		return acceptHandshake(server, d, protocol.NewInstruction("ready", "$conn"))
+	})
+	tun := NewTunnelWithTransport(client, nil)
+	if err := tun.HandshakeContext(context.Background(), protocol.NewHandshakeConfig(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if tun.ConnId() != "$conn" || tun.ProtocolVersion() != protocol.Version150 {
+		t.Errorf("unexpected connection %s %s", tun.ConnId(), tun.ProtocolVersion())
+	}
+}
+
+func TestHandshakeContextTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	// guacd reads select but never answers
+	fakeGuacd(t, server, func(d *protocol.Decoder) bool { return true })
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := NewTunnelWithTransport(client, nil).HandshakeContext(ctx, protocol.NewHandshakeConfig(nil))
+	var handshakeErr *HandshakeError
+	if !errors.As(err, &handshakeErr) || handshakeErr.Phase != PhaseArgs || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want deadline exceeded in phase %s", err, PhaseArgs)
+	}
+}
+
+func TestHandshakePrematureEOF(t *testing.T) {
+	for name, partial := range map[string]string{
+		"between instructions": "",
+		"within args":          "4.args,13.VERSION_1_5_0,",
+	} {
+		client, server := net.Pipe()
+		fakeGuacd(t, server, func(d *protocol.Decoder) bool {
+			_, _ = d.Decode()
+			_, _ = server.Write([]byte(partial))
+			return false
+		})
+		err := NewTunnelWithTransport(client, nil).Handshake(protocol.NewHandshakeConfig(nil))
+		if !errors.Is(err, ErrPrematureEOF) {
+			t.Fatalf("%s: got %v, want %v", name, err, ErrPrematureEOF)
+		}
+		var decodeErr *protocol.DecodeError
+		if partial != "" && (!errors.As(err, &decodeErr) || decodeErr.Offset != len(partial)) {
+			t.Fatalf("%s: got %v, want a decode error at offset %d", name, err, len(partial))
+		}
+	}
+}
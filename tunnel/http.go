@@ -0,0 +1,352 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/riete/go-guac/protocol"
+)
+
+// Protocol of Guacamole.HTTPTunnel in guacamole-common-js:
+//
+//	POST ?connect           body is the connect data, response is the tunnel UUID and the Guacamole-Tunnel-Token header
+//	GET  ?read:UUID:N       response streams instructions and ends with "0.;"
+//	POST ?write:UUID        body contains instructions for guacd
+//
+// Read and write requests must carry the token in the Guacamole-Tunnel-Token header, as guacamole-common-js 1.4+ does.
+// Errors are reported with the Guacamole-Status-Code and Guacamole-Error-Message headers
+const (
+	httpTunnelTokenHeader  = "Guacamole-Tunnel-Token"
+	httpStatusCodeHeader   = "Guacamole-Status-Code"
+	httpErrorMessageHeader = "Guacamole-Error-Message"
+	httpReadEnd            = "0.;"
+
+	defaultReadChunkSize  = 8192
+	defaultPollTimeout    = 10 * time.Second
+	defaultAbandonTimeout = 30 * time.Second
+	httpTransportBuffer   = 1024
+)
+
+// httpTransport is the ClientTransport of a tunnel whose client polls over HTTP
+type httpTransport struct {
+	toClient   chan protocol.Instruction
+	fromClient chan protocol.Instruction
+	closed     chan struct{}
+	once       sync.Once
+	lastAccess atomic.Int64
+
+	// read requests are served one at a time, a queued reader ends the current response
+	readMu  sync.Mutex
+	waiting atomic.Int32
+	wake    chan struct{}
+}
+
+func (h *httpTransport) ReadInstruction() (protocol.Instruction, error) {
+	select {
+	case instr := <-h.fromClient:
+		return instr, nil
+	case <-h.closed:
+		return "", io.EOF
+	}
+}
+
+func (h *httpTransport) WriteInstruction(instr protocol.Instruction) error {
+	select {
+	case h.toClient <- instr:
+		return nil
+	case <-h.closed:
+		return io.ErrClosedPipe
+	}
+}
+
+// Ping always succeeds, abandoned HTTP tunnels are detected by HTTPHandler instead
+func (h *httpTransport) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (h *httpTransport) Close() error {
+	h.once.Do(func() {
+		close(h.closed)
+	})
+	return nil
+}
+
+func (h *httpTransport) touch() {
+	h.lastAccess.Store(time.Now().UnixNano())
+}
+
+func newHTTPTransport() *httpTransport {
+	h := &httpTransport{
+		toClient:   make(chan protocol.Instruction, httpTransportBuffer),
+		fromClient: make(chan protocol.Instruction, httpTransportBuffer),
+		closed:     make(chan struct{}),
+		wake:       make(chan struct{}, 1),
+	}
+	h.touch()
+	return h
+}
+
+type httpTunnel struct {
+	tunnel    *Tunnel
+	transport *httpTransport
+	token     string
+}
+
+// HTTPConnectFunc creates a tunnel using client as transport and performs the handshake for a connect request.
+// The body of r is the data passed to Guacamole.HTTPTunnel.connect, usually parsed with r.ParseForm.
+// Return a *protocol.Error to control the status reported to the browser
+type HTTPConnectFunc func(r *http.Request, client ClientTransport) (*Tunnel, error)
+
+type HTTPHandlerOption func(*HTTPHandler)
+
+// WithReadChunkSize limits the bytes written by one read response before it ends and the client polls again
+func WithReadChunkSize(n int) HTTPHandlerOption {
+	return func(h *HTTPHandler) {
+		if n > 0 {
+			h.readChunkSize = n
+		}
+	}
+}
+
+// WithPollTimeout is how long a read request waits for data before ending empty
+func WithPollTimeout(d time.Duration) HTTPHandlerOption {
+	return func(h *HTTPHandler) {
+		if d > 0 {
+			h.pollTimeout = d
+		}
+	}
+}
+
+// WithAbandonTimeout closes tunnels which received neither read nor write requests for d
+func WithAbandonTimeout(d time.Duration) HTTPHandlerOption {
+	return func(h *HTTPHandler) {
+		if d > 0 {
+			h.abandonTimeout = d
+		}
+	}
+}
+
+// HTTPHandler implements the HTTP tunnel of guacamole-common-js, for networks where WebSocket is not available
+type HTTPHandler struct {
+	connect        HTTPConnectFunc
+	tunnels        map[string]*httpTunnel
+	mu             sync.Mutex
+	readChunkSize  int
+	pollTimeout    time.Duration
+	abandonTimeout time.Duration
+	ctx            context.Context
+	cancel         context.CancelFunc
+}
+
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.RawQuery
+	switch {
+	case query == "connect":
+		h.handleConnect(w, r)
+	case strings.HasPrefix(query, "read:"):
+		// read:UUID:N, N only defeats caching
+		uuid, _, _ := strings.Cut(strings.TrimPrefix(query, "read:"), ":")
+		if ht := h.lookup(w, r, uuid); ht != nil {
+			h.handleRead(w, uuid, ht)
+		}
+	case strings.HasPrefix(query, "write:"):
+		if ht := h.lookup(w, r, strings.TrimPrefix(query, "write:")); ht != nil {
+			h.handleWrite(w, r, ht)
+		}
+	default:
+		writeHTTPError(w, protocol.ClientBadRequest, "Invalid tunnel operation: "+query)
+	}
+}
+
+func writeHTTPError(w http.ResponseWriter, status protocol.StatusCode, message string) {
+	w.Header().Set(httpStatusCodeHeader, strconv.Itoa(int(status)))
+	w.Header().Set(httpErrorMessageHeader, message)
+	http.Error(w, message, status.HTTPStatus())
+}
+
+func (h *HTTPHandler) lookup(w http.ResponseWriter, r *http.Request, uuid string) *httpTunnel {
+	h.mu.Lock()
+	ht, exists := h.tunnels[uuid]
+	h.mu.Unlock()
+	if !exists {
+		writeHTTPError(w, protocol.ResourceNotFound, "No such tunnel.")
+		return nil
+	}
+	token := r.Header.Get(httpTunnelTokenHeader)
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(ht.token)) != 1 {
+		writeHTTPError(w, protocol.ClientForbidden, "Invalid tunnel token.")
+		return nil
+	}
+	ht.transport.touch()
+	return ht
+}
+
+func (h *HTTPHandler) remove(uuid string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.tunnels, uuid)
+}
+
+func (h *HTTPHandler) handleConnect(w http.ResponseWriter, r *http.Request) {
+	transport := newHTTPTransport()
+	t, err := h.connect(r, transport)
+	if err != nil {
+		_ = transport.Close()
+		status := protocol.ServerError
+		var guacErr *protocol.Error
+		if errors.As(err, &guacErr) {
+			status = guacErr.Status
+		}
+		writeHTTPError(w, status, err.Error())
+		return
+	}
+	uuid := t.UUID()
+	ht := &httpTunnel{tunnel: t, transport: transport, token: newUUID()}
+	h.mu.Lock()
+	h.tunnels[uuid] = ht
+	h.mu.Unlock()
+	go func() {
+		_ = t.Forward(h.ctx)
+		t.Close()
+	}()
+
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set(httpTunnelTokenHeader, ht.token)
+	_, _ = io.WriteString(w, uuid)
+}
+
+func (h *HTTPHandler) handleRead(w http.ResponseWriter, uuid string, ht *httpTunnel) {
+	transport := ht.transport
+	transport.waiting.Add(1)
+	select {
+	case transport.wake <- struct{}{}:
+	default:
+	}
+	transport.readMu.Lock()
+	defer transport.readMu.Unlock()
+	transport.waiting.Add(-1)
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+	timer := time.NewTimer(h.pollTimeout)
+	defer timer.Stop()
+	written := 0
+	for written < h.readChunkSize {
+		var instr protocol.Instruction
+		select {
+		case instr = <-transport.toClient:
+		default:
+			if flusher != nil && written > 0 {
+				flusher.Flush()
+			}
+			select {
+			case instr = <-transport.toClient:
+			case <-transport.wake:
+				if transport.waiting.Load() > 0 {
+					goto end
+				}
+				continue
+			case <-timer.C:
+				goto end
+			case <-transport.closed:
+				// deliver what the tunnel wrote before closing, then forget it
+				select {
+				case instr = <-transport.toClient:
+				default:
+					h.remove(uuid)
+					goto end
+				}
+			}
+		}
+		n, err := io.WriteString(w, string(instr))
+		if err != nil {
+			return
+		}
+		written += n
+		transport.touch()
+	}
+end:
+	_, _ = io.WriteString(w, httpReadEnd)
+}
+
+func (h *HTTPHandler) handleWrite(w http.ResponseWriter, r *http.Request, ht *httpTunnel) {
+	decoder := protocol.NewDecoder(r.Body, protocol.WithMaxInstructionLength(maxClientInstructionLength))
+	for {
+		instr, err := decoder.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeHTTPError(w, protocol.ClientBadRequest, fmt.Sprintf("Invalid instruction: %s", err.Error()))
+			return
+		}
+		select {
+		case ht.transport.fromClient <- instr:
+		case <-ht.transport.closed:
+			writeHTTPError(w, protocol.ResourceClosed, "Tunnel closed.")
+			return
+		}
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+}
+
+// closeAbandoned closes the tunnels of clients which stopped polling
+func (h *HTTPHandler) closeAbandoned() {
+	ticker := time.NewTicker(h.abandonTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-ticker.C:
+			deadline := time.Now().Add(-h.abandonTimeout).UnixNano()
+			h.mu.Lock()
+			for uuid, ht := range h.tunnels {
+				if ht.transport.lastAccess.Load() < deadline {
+					delete(h.tunnels, uuid)
+					go ht.tunnel.Close()
+				}
+			}
+			h.mu.Unlock()
+		}
+	}
+}
+
+// Close ends the forwarding of all tunnels and stops the cleanup of abandoned tunnels
+func (h *HTTPHandler) Close() {
+	h.cancel()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for uuid := range h.tunnels {
+		delete(h.tunnels, uuid)
+	}
+}
+
+func NewHTTPHandler(connect HTTPConnectFunc, opts ...HTTPHandlerOption) *HTTPHandler {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &HTTPHandler{
+		connect:        connect,
+		tunnels:        make(map[string]*httpTunnel),
+		readChunkSize:  defaultReadChunkSize,
+		pollTimeout:    defaultPollTimeout,
+		abandonTimeout: defaultAbandonTimeout,
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	go h.closeAbandoned()
+	return h
+}
@@ -0,0 +1,98 @@
+package tunnel
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/riete/go-guac/protocol"
+)
+
+func TestHTTPHandler(t *testing.T) {
+	guacdClient, guacdServer := net.Pipe()
+	sync := protocol.NewInstruction("sync", "1")
+	key := protocol.Key{Keysym: 65307, Pressed: true}.Marshal()
+	received := make(chan protocol.Instruction, 1)
+	fakeGuacd(t, guacdServer, func(d *protocol.Decoder) bool {
+		if !acceptHandshake(guacdServer, d, protocol.NewInstruction("ready", "$conn")+sync) {
+			return false
+		}
+		instr, err := d.Decode()
+		if err == nil {
+			received <- instr
+		}
+		return err == nil
+	})
+
+	handler := NewHTTPHandler(func(r *http.Request, client ClientTransport) (*Tunnel, error) {
+		tun := NewTunnelWithTransport(guacdClient, client)
+		return tun, tun.Handshake(protocol.NewHandshakeConfig(nil))
+	}, WithPollTimeout(time.Second))
+	defer handler.Close()
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"?connect", "application/x-www-form-urlencoded", strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	uuid, token := string(body), resp.Header.Get(httpTunnelTokenHeader)
+	if resp.StatusCode != http.StatusOK || uuid == "" || token == "" {
+		t.Fatalf("connect failed: %d %q %q", resp.StatusCode, body, token)
+	}
+
+	for _, wrong := range []string{"", "wrong"} {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"?write:"+uuid, strings.NewReader(string(key)))
+		req.Header.Set(httpTunnelTokenHeader, wrong)
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("write with token %q got %d, want 403", wrong, resp.StatusCode)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"?read:"+uuid+":0", nil)
+	req.Header.Set(httpTunnelTokenHeader, token)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != string(sync)+httpReadEnd {
+		t.Fatalf("got %q, want %q", body, string(sync)+httpReadEnd)
+	}
+
+	req, _ = http.NewRequest(http.MethodPost, server.URL+"?write:"+uuid, strings.NewReader(string(key)))
+	req.Header.Set(httpTunnelTokenHeader, token)
+	if resp, err = http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("write failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	select {
+	case instr := <-received:
+		if instr != key {
+			t.Fatalf("got %q, want %q", instr, key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("key not forwarded to guacd")
+	}
+
+	resp, err = http.Get(server.URL + "?read:unknown:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound || resp.Header.Get(httpStatusCodeHeader) != "516" {
+		t.Fatalf("got %d %s, want 404 516", resp.StatusCode, resp.Header.Get(httpStatusCodeHeader))
+	}
+}
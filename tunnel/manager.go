@@ -0,0 +1,152 @@
+package tunnel
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/riete/go-guac/protocol"
+)
+
+// SessionInfo is a snapshot of a registered tunnel
+type SessionInfo struct {
+	UUID     string
+	ConnId   string
+	User     string
+	Protocol string
+	Host     string
+	Joined   bool
+	ReadOnly bool
+	Started  time.Time
+	Stats
+}
+
+func (s *session) info() SessionInfo {
+	t := s.tunnel
+	return SessionInfo{
+		UUID:     t.uuid,
+		ConnId:   t.ConnId(),
+		User:     s.user,
+		Protocol: t.protocol,
+		Host:     t.hostname,
+		Joined:   t.joined,
+		ReadOnly: t.ReadOnly(),
+		Started:  s.started,
+		Stats:    t.Stats(),
+	}
+}
+
+// Manager lists, inspects and terminates the sessions tracked in its Registry by connection ID.
+// A connection has the session of its owner and those of the tunnels which joined it
+type Manager struct {
+	registry *Registry
+	mu       sync.Mutex // guards closed, status and message
+	closed   bool
+	status   protocol.StatusCode
+	message  string
+}
+
+func (m *Manager) add(connId string, t *Tunnel, user string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		// shutting down, refuse sessions which completed their handshake meanwhile
+		terminate(t, m.status, m.message)
+		return
+	}
+	m.registry.add(connId, newSession(t, user))
+}
+
+// Registry returns the registry the sessions are tracked in, e.g. to look up connections to join
+func (m *Manager) Registry() *Registry {
+	return m.registry
+}
+
+// Sessions returns snapshots of all sessions sorted by start time
+func (m *Manager) Sessions() []SessionInfo {
+	sessions := m.registry.all()
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, s := range sessions {
+		infos = append(infos, s.info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Started.Before(infos[j].Started)
+	})
+	return infos
+}
+
+// Lookup returns a snapshot of the session owning the connection
+func (m *Manager) Lookup(connId string) (SessionInfo, bool) {
+	m.registry.mu.RLock()
+	defer m.registry.mu.RUnlock()
+	s, exists := m.registry.owner(connId)
+	if !exists {
+		return SessionInfo{}, false
+	}
+	return s.info(), true
+}
+
+// Tunnel returns the tunnel owning the connection, e.g. to toggle read-only
+func (m *Manager) Tunnel(connId string) (*Tunnel, bool) {
+	return m.registry.Lookup(connId)
+}
+
+// terminate starts a graceful stop of the tunnel without waiting for it, the client receives message and status
+// as an error instruction and Forward returns the same error
+func terminate(t *Tunnel, status protocol.StatusCode, message string) {
+	go func() {
+		_ = t.Shutdown(context.Background(), status, message)
+	}()
+}
+
+// Terminate stops all sessions of the connection gracefully without waiting for them, the owner and every tunnel
+// which joined it. The browsers receive message and status as an error instruction. It returns the number of sessions
+func (m *Manager) Terminate(connId string, status protocol.StatusCode, message string) int {
+	tunnels := m.registry.Tunnels(connId)
+	for _, t := range tunnels {
+		terminate(t, status, message)
+	}
+	return len(tunnels)
+}
+
+// Shutdown terminates all sessions with status and message, refuses sessions connecting afterwards,
+// and waits until every session disconnected or ctx is done. Sessions disconnect when their tunnel is closed,
+// usually right after Forward returned
+func (m *Manager) Shutdown(ctx context.Context, status protocol.StatusCode, message string) error {
+	m.mu.Lock()
+	m.closed = true
+	m.status = status
+	m.message = message
+	sessions := m.registry.all()
+	m.mu.Unlock()
+
+	for _, s := range sessions {
+		terminate(s.tunnel, status, message)
+	}
+	for _, s := range sessions {
+		select {
+		case <-s.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func NewManager() *Manager {
+	return &Manager{registry: NewRegistry()}
+}
+
+// WithManager registers the tunnel in the registry of m once connected and removes it on disconnect,
+// user is kept as metadata. It replaces WithRegistry for that registry
+func WithManager(m *Manager, user string) TunnelOption {
+	return func(t *Tunnel) {
+		WithOnConnect(func(connId string) {
+			m.add(connId, t, user)
+		})(t)
+		WithOnDisconnect(func(connId string) {
+			m.registry.remove(connId, t)
+		})(t)
+	}
+}
@@ -0,0 +1,66 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/riete/go-guac/protocol"
+)
+
+func TestManager(t *testing.T) {
+	guacdClient, guacdServer := net.Pipe()
+	fakeGuacd(t, guacdServer, func(d *protocol.Decoder) bool {
+		return acceptHandshake(guacdServer, d, protocol.NewInstruction("ready", "$conn"))
+	})
+	m := NewManager()
+	browser, client := NewPipeTransport()
+	tun := NewTunnelWithTransport(guacdClient, client, WithManager(m, "alice"))
+	defer tun.Close()
+	config := protocol.NewHandshakeConfig(nil, protocol.WithProtocol("vnc"), protocol.WithHostPort("10.0.0.1", "5900"))
+	if err := tun.Handshake(config); err != nil {
+		t.Fatal(err)
+	}
+	forwarded := make(chan error, 1)
+	go func() { forwarded <- tun.Forward(context.Background()) }()
+	_, _ = browser.ReadInstruction() // tunnel uuid
+
+	sessions := m.Sessions()
+	if len(sessions) != 1 {
+		t.Fatalf("got %d sessions, want 1", len(sessions))
+	}
+	if s := sessions[0]; s.UUID != tun.UUID() || s.ConnId != "$conn" || s.User != "alice" || s.Protocol != "vnc" || s.Host != "10.0.0.1" {
+		t.Fatalf("unexpected session %+v", s)
+	}
+
+	if owner, exists := m.Tunnel("$conn"); !exists || owner != tun {
+		t.Fatal("connection not found")
+	}
+	if n := m.Terminate("$conn", protocol.SessionClosed, "terminated by admin"); n != 1 {
+		t.Fatalf("terminated %d sessions, want 1", n)
+	}
+	want := protocol.NewError(protocol.SessionClosed, "terminated by admin").Marshal()
+	if instr, err := browser.ReadInstruction(); err != nil || instr != want {
+		t.Fatalf("got %q %v, want %q", instr, err, want)
+	}
+	// the browser closes its side once told
+	_ = browser.Close()
+	select {
+	case err := <-forwarded:
+		var guacErr *protocol.Error
+		if !errors.As(err, &guacErr) || guacErr.Status != protocol.SessionClosed {
+			t.Fatalf("Forward returned %v, want session closed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Forward did not return")
+	}
+	tun.Close()
+	if _, exists := m.Lookup("$conn"); exists {
+		t.Fatal("terminated session still registered")
+	}
+	if err := m.Shutdown(context.Background(), protocol.ServerError, "shutting down"); err != nil {
+		t.Fatal(err)
+	}
+}
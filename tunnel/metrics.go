@@ -0,0 +1,177 @@
+package tunnel
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/riete/go-guac/protocol"
+)
+
+// Direction is the direction instructions are forwarded in
+type Direction int
+
+const (
+	// ClientToGuacd is input from the client, such as mouse and key
+	ClientToGuacd Direction = iota
+	// GuacdToClient is output of the remote session, such as drawing and sync
+	GuacdToClient
+)
+
+func (d Direction) String() string {
+	if d == ClientToGuacd {
+		return "client_to_guacd"
+	}
+	return "guacd_to_client"
+}
+
+// Metrics receives the measurements of every tunnel created WithMetrics, aggregating them is up to the implementation.
+// Methods are called from the forwarding goroutines and must be safe for concurrent use
+type Metrics interface {
+	// Handshake is called when the handshake completed or failed
+	Handshake(t *Tunnel, latency time.Duration, err error)
+	// SessionStarted is called when Forward starts
+	SessionStarted(t *Tunnel)
+	// Instruction is called for every instruction forwarded, size is its length in bytes
+	Instruction(t *Tunnel, direction Direction, opcode string, size int)
+	// RoundTrip is called when the client answers a sync, latency is the time since the sync was sent to it
+	RoundTrip(t *Tunnel, latency time.Duration)
+	// SessionEnded is called when Forward returns, status is derived from its error by CloseStatus
+	SessionEnded(t *Tunnel, duration time.Duration, status protocol.StatusCode)
+}
+
+// WithMetrics reports the measurements of the tunnel to m
+func WithMetrics(m Metrics) TunnelOption {
+	return func(t *Tunnel) {
+		t.metrics = m
+	}
+}
+
+// CloseStatus derives the status a session ended with from the error returned by Forward:
+// protocol.Success for nil, the status of a *protocol.Error, e.g. reported by guacd or set by Shutdown,
+// and protocol.ServerError for any other error
+func CloseStatus(err error) protocol.StatusCode {
+	if err == nil {
+		return protocol.Success
+	}
+	var guacErr *protocol.Error
+	if errors.As(err, &guacErr) {
+		return guacErr.Status
+	}
+	return protocol.ServerError
+}
+
+// Stats is a snapshot of the measurements of a single tunnel
+type Stats struct {
+	// BytesIn and InstructionsIn count what was forwarded from the client to guacd
+	BytesIn        int64
+	InstructionsIn int64
+	// BytesOut and InstructionsOut count what was forwarded from guacd to the client
+	BytesOut         int64
+	InstructionsOut  int64
+	HandshakeLatency time.Duration
+	// RoundTrip is the latest time between sending a sync to the client and its answer
+	RoundTrip time.Duration
+}
+
+// Stats returns the measurements of the tunnel so far, they are collected with or without WithMetrics
+func (t *Tunnel) Stats() Stats {
+	return Stats{
+		BytesIn:          t.bytesIn.Load(),
+		InstructionsIn:   t.instructionsIn.Load(),
+		BytesOut:         t.bytesOut.Load(),
+		InstructionsOut:  t.instructionsOut.Load(),
+		HandshakeLatency: time.Duration(t.handshakeLatency.Load()),
+		RoundTrip:        time.Duration(t.roundTrip.Load()),
+	}
+}
+
+// pendingSyncs is the number of sync instructions awaiting the answer of the client which are remembered,
+// guacd does not send more frames while the client lags behind
+const pendingSyncs = 8
+
+type pendingSync struct {
+	timestamp string
+	sent      time.Time
+}
+
+// syncTracker measures the round trip of sync instructions, the client answers each frame with its timestamp
+type syncTracker struct {
+	mu      sync.Mutex
+	pending [pendingSyncs]pendingSync
+	next    int
+}
+
+func (s *syncTracker) sent(timestamp string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[s.next] = pendingSync{timestamp: timestamp, sent: time.Now()}
+	s.next = (s.next + 1) % pendingSyncs
+}
+
+func (s *syncTracker) answered(timestamp string) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, p := range s.pending {
+		if p.timestamp == timestamp && !p.sent.IsZero() {
+			s.pending[i] = pendingSync{}
+			return time.Since(p.sent), true
+		}
+	}
+	return 0, false
+}
+
+// syncTimestamp returns the timestamp of a sync instruction
+func syncTimestamp(instr protocol.Instruction) (string, bool) {
+	if instr.Opcode().Value() != protocol.OpSync {
+		return "", false
+	}
+	values, err := instr.Values()
+	if err != nil || len(values) < 2 {
+		return "", false
+	}
+	return values[1], true
+}
+
+// forwarded updates the measurements after instr was forwarded in direction. Middlewares which rewrote an instruction
+// may have returned several concatenated ones, they are measured one by one
+func (t *Tunnel) forwarded(direction Direction, instr protocol.Instruction, rewritten bool) {
+	if !rewritten {
+		t.measure(direction, instr)
+		return
+	}
+	d := protocol.NewDecoder(strings.NewReader(string(instr)))
+	for {
+		next, err := d.Decode()
+		if err != nil {
+			return
+		}
+		t.measure(direction, next)
+	}
+}
+
+// measure updates the measurements for a single forwarded instruction
+func (t *Tunnel) measure(direction Direction, instr protocol.Instruction) {
+	size := int64(len(instr))
+	if direction == ClientToGuacd {
+		t.bytesIn.Add(size)
+		t.instructionsIn.Add(1)
+	} else {
+		t.bytesOut.Add(size)
+		t.instructionsOut.Add(1)
+	}
+	if timestamp, ok := syncTimestamp(instr); ok {
+		if direction == GuacdToClient {
+			t.syncs.sent(timestamp)
+		} else if latency, ok := t.syncs.answered(timestamp); ok {
+			t.roundTrip.Store(int64(latency))
+			if t.metrics != nil {
+				t.metrics.RoundTrip(t, latency)
+			}
+		}
+	}
+	if t.metrics != nil {
+		t.metrics.Instruction(t, direction, instr.Opcode().Value(), int(size))
+	}
+}
@@ -0,0 +1,169 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/riete/go-guac/protocol"
+)
+
+type recordingMetrics struct {
+	mu           sync.Mutex
+	handshakes   int
+	started      int
+	instructions map[Direction][]string
+	roundTrips   int
+	ended        []protocol.StatusCode
+}
+
+func (m *recordingMetrics) Handshake(t *Tunnel, latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handshakes++
+}
+
+func (m *recordingMetrics) SessionStarted(t *Tunnel) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.started++
+}
+
+func (m *recordingMetrics) Instruction(t *Tunnel, direction Direction, opcode string, size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.instructions[direction] = append(m.instructions[direction], opcode)
+}
+
+func (m *recordingMetrics) RoundTrip(t *Tunnel, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.roundTrips++
+}
+
+func (m *recordingMetrics) SessionEnded(t *Tunnel, duration time.Duration, status protocol.StatusCode) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ended = append(m.ended, status)
+}
+
+func TestMetrics(t *testing.T) {
+	syncInstr := protocol.NewInstruction(protocol.OpSync, "1234")
+	guacdClient, guacdServer := net.Pipe()
+	fakeGuacd(t, guacdServer, func(d *protocol.Decoder) bool {
+		if !acceptHandshake(guacdServer, d, protocol.NewInstruction("ready", "$conn")) {
+			return false
+		}
+		_, err := guacdServer.Write(syncInstr.Byte())
+		return err == nil
+	})
+	m := &recordingMetrics{instructions: make(map[Direction][]string)}
+	browser, client := NewPipeTransport()
+	tun := NewTunnelWithTransport(guacdClient, client, WithMetrics(m))
+	t.Cleanup(tun.Close)
+	if err := tun.Handshake(protocol.NewHandshakeConfig(nil)); err != nil {
+		t.Fatal(err)
+	}
+	forwarded := make(chan error, 1)
+	go func() { forwarded <- tun.Forward(context.Background()) }()
+
+	_, _ = browser.ReadInstruction() // tunnel uuid
+	if instr, err := browser.ReadInstruction(); err != nil || instr != syncInstr {
+		t.Fatalf("got %q %v, want sync", instr, err)
+	}
+	_ = browser.WriteInstruction(syncInstr)
+	_ = browser.WriteInstruction(protocol.Key{Keysym: 65, Pressed: true}.Marshal())
+	for tun.Stats().InstructionsIn < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	stats := tun.Stats()
+	if stats.InstructionsOut != 1 || stats.BytesOut != int64(len(syncInstr)) || stats.RoundTrip <= 0 || stats.HandshakeLatency <= 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	_ = browser.Close()
+	select {
+	case err := <-forwarded:
+		if err != nil {
+			t.Fatalf("Forward returned %v after the client closed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Forward did not return")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.handshakes != 1 || m.started != 1 || m.roundTrips != 1 {
+		t.Fatalf("got %d handshakes, %d sessions, %d round trips, want 1 each", m.handshakes, m.started, m.roundTrips)
+	}
+	if in := m.instructions[ClientToGuacd]; len(in) != 2 || in[0] != protocol.OpSync || in[1] != protocol.OpKey {
+		t.Fatalf("got client instructions %v", in)
+	}
+	if len(m.ended) != 1 || m.ended[0] != protocol.Success {
+		t.Fatalf("got ended %v, want success", m.ended)
+	}
+}
+
+// TestMetricsRewritten injects an instruction in front of sync, both are measured on their own
+func TestMetricsRewritten(t *testing.T) {
+	syncInstr := protocol.NewInstruction(protocol.OpSync, "1234")
+	guacdClient, guacdServer := net.Pipe()
+	fakeGuacd(t, guacdServer, func(d *protocol.Decoder) bool {
+		if !acceptHandshake(guacdServer, d, protocol.NewInstruction("ready", "$conn")) {
+			return false
+		}
+		_, err := guacdServer.Write(syncInstr.Byte())
+		return err == nil
+	})
+	m := &recordingMetrics{instructions: make(map[Direction][]string)}
+	inject := func(ctx context.Context, instr protocol.Instruction) (protocol.Instruction, bool, error) {
+		if instr.Opcode().Value() == protocol.OpSync {
+			return protocol.Nop + instr, true, nil
+		}
+		return instr, true, nil
+	}
+	browser, client := NewPipeTransport()
+	tun := NewTunnelWithTransport(guacdClient, client, WithMetrics(m), WithGuacdMiddleware(inject))
+	t.Cleanup(tun.Close)
+	if err := tun.Handshake(protocol.NewHandshakeConfig(nil)); err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = tun.Forward(context.Background()) }()
+
+	_, _ = browser.ReadInstruction() // tunnel uuid
+	if instr, err := browser.ReadInstruction(); err != nil || instr != protocol.Nop {
+		t.Fatalf("got %q %v, want nop", instr, err)
+	}
+	if instr, err := browser.ReadInstruction(); err != nil || instr != syncInstr {
+		t.Fatalf("got %q %v, want sync", instr, err)
+	}
+	_ = browser.WriteInstruction(syncInstr)
+	for tun.Stats().InstructionsIn < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if stats := tun.Stats(); stats.InstructionsOut != 2 || stats.RoundTrip <= 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if out := m.instructions[GuacdToClient]; len(out) != 2 || out[0] != protocol.OpNop || out[1] != protocol.OpSync {
+		t.Fatalf("got guacd instructions %v", out)
+	}
+}
+
+func TestCloseStatus(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		want protocol.StatusCode
+	}{
+		{nil, protocol.Success},
+		{errors.New("read data from guacd error: EOF"), protocol.ServerError},
+		{&HandshakeError{Phase: PhaseReady, Err: protocol.NewError(protocol.ClientUnauthorized, "denied")}, protocol.ClientUnauthorized},
+	} {
+		if got := CloseStatus(c.err); got != c.want {
+			t.Errorf("CloseStatus(%v) = %s, want %s", c.err, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/riete/go-guac/protocol"
+)
+
+// Middleware inspects a whole instruction on its way through the tunnel. It returns the instruction to pass on,
+// which may be rewritten or several concatenated instructions, or false to drop it.
+// A returned error ends forwarding. Use TunnelFromContext to reach the tunnel, e.g. to answer the sender
+type Middleware func(ctx context.Context, instr protocol.Instruction) (protocol.Instruction, bool, error)
+
+// WithGuacdMiddleware adds middlewares for instructions from guacd to the client, run in the order added,
+// before WithOnReadFromGuacd and the recorder see the data
+func WithGuacdMiddleware(m ...Middleware) TunnelOption {
+	return func(t *Tunnel) {
+		t.guacdMiddleware = append(t.guacdMiddleware, m...)
+	}
+}
+
+// WithClientMiddleware adds middlewares for instructions from the client to guacd, run in the order added,
+// before WithOnReadFromWs sees the data. Internal instructions of the tunnel never pass through them
+func WithClientMiddleware(m ...Middleware) TunnelOption {
+	return func(t *Tunnel) {
+		t.clientMiddleware = append(t.clientMiddleware, m...)
+	}
+}
+
+type tunnelContextKey struct{}
+
+// TunnelFromContext returns the tunnel running the middleware, or nil outside Forward
+func TunnelFromContext(ctx context.Context) *Tunnel {
+	t, _ := ctx.Value(tunnelContextKey{}).(*Tunnel)
+	return t
+}
+
+type middlewareChain []Middleware
+
+// apply runs instr through the chain and returns what is left, an empty instruction if it was dropped.
+// If a middleware returns several instructions, the rest of the chain sees each of them separately
+func (c middlewareChain) apply(ctx context.Context, instr protocol.Instruction) (protocol.Instruction, error) {
+	for i, m := range c {
+		out, ok, err := m(ctx, instr)
+		if err != nil {
+			return "", err
+		}
+		if !ok || out == "" {
+			return "", nil
+		}
+		if out == instr {
+			continue
+		}
+		d := protocol.NewDecoder(strings.NewReader(string(out)))
+		first, err := d.Decode()
+		if err != nil {
+			return "", fmt.Errorf("middleware returned malformed instruction: %s", err.Error())
+		}
+		if first == out {
+			instr = out
+			continue
+		}
+		result, err := c[i+1:].apply(ctx, first)
+		if err != nil {
+			return "", err
+		}
+		for {
+			next, err := d.Decode()
+			if err == io.EOF {
+				return result, nil
+			}
+			if err != nil {
+				return "", fmt.Errorf("middleware returned malformed instruction: %s", err.Error())
+			}
+			r, err := c[i+1:].apply(ctx, next)
+			if err != nil {
+				return "", err
+			}
+			result += r
+		}
+	}
+	return instr, nil
+}
+
+// WriteToClient sends instructions to the client directly, bypassing the middlewares, e.g. to answer a blocked request
+func (t *Tunnel) WriteToClient(instr protocol.Instruction) error {
+	return t.client.WriteInstruction(instr)
+}
+
+// WriteToGuacd sends instructions to guacd directly, bypassing the middlewares
+func (t *Tunnel) WriteToGuacd(instr protocol.Instruction) error {
+	return t.writeGuacd(instr.Byte())
+}
@@ -0,0 +1,88 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/riete/go-guac/protocol"
+)
+
+func TestMiddlewareChain(t *testing.T) {
+	sync := protocol.NewInstruction("sync", "1")
+	var seen []protocol.Instruction
+	chain := middlewareChain{
+		// inject a nop before every sync
+		func(ctx context.Context, instr protocol.Instruction) (protocol.Instruction, bool, error) {
+			if instr.Opcode().Value() == protocol.OpSync {
+				return protocol.Nop + instr, true, nil
+			}
+			return instr, true, nil
+		},
+		// drop the injected nop, recording what passes
+		func(ctx context.Context, instr protocol.Instruction) (protocol.Instruction, bool, error) {
+			seen = append(seen, instr)
+			return instr, instr != protocol.Nop, nil
+		},
+	}
+	out, err := chain.apply(context.Background(), sync)
+	if err != nil || out != sync {
+		t.Fatalf("got %q %v, want %q", out, err, sync)
+	}
+	if len(seen) != 2 || seen[0] != protocol.Nop || seen[1] != sync {
+		t.Fatalf("later middleware saw %q, want nop and sync separately", seen)
+	}
+
+	blocked := errors.New("blocked")
+	chain = middlewareChain{
+		func(ctx context.Context, instr protocol.Instruction) (protocol.Instruction, bool, error) {
+			return "", false, blocked
+		},
+	}
+	if _, err = chain.apply(context.Background(), sync); !errors.Is(err, blocked) {
+		t.Fatalf("got %v, want %v", err, blocked)
+	}
+}
+
+func TestClientMiddleware(t *testing.T) {
+	guacdClient, guacdServer := net.Pipe()
+	received := make(chan protocol.Instruction, 1)
+	fakeGuacd(t, guacdServer, func(d *protocol.Decoder) bool {
+		instr, err := d.Decode()
+		if err == nil {
+			received <- instr
+		}
+		return err == nil
+	})
+
+	browser, client := NewPipeTransport()
+	var fromContext *Tunnel
+	rewrite := func(ctx context.Context, instr protocol.Instruction) (protocol.Instruction, bool, error) {
+		fromContext = TunnelFromContext(ctx)
+		if instr.Opcode().Value() == protocol.OpKey {
+			return protocol.Key{Keysym: 65, Pressed: true}.Marshal(), true, nil
+		}
+		return instr, instr.Opcode().Value() != protocol.OpClipboard, nil
+	}
+	tun := NewTunnelWithTransport(guacdClient, client, WithClientMiddleware(rewrite))
+	defer tun.Close()
+	go func() { _ = tun.Forward(context.Background()) }()
+	_, _ = browser.ReadInstruction() // tunnel uuid
+
+	_ = browser.WriteInstruction(protocol.Clipboard{Stream: 1, Mimetype: "text/plain"}.Marshal())
+	_ = browser.WriteInstruction(protocol.Key{Keysym: 65307, Pressed: true}.Marshal())
+	want := protocol.Key{Keysym: 65, Pressed: true}.Marshal()
+	select {
+	case instr := <-received:
+		if instr != want {
+			t.Fatalf("guacd got %q, want %q", instr, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("key not forwarded to guacd")
+	}
+	if fromContext != tun {
+		t.Fatal("TunnelFromContext did not return the tunnel")
+	}
+}
@@ -0,0 +1,103 @@
+package tunnel
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/riete/go-guac/protocol"
+)
+
+// readOnlyMessage is sent in the ack refusing a stream opened by the client while read-only
+const readOnlyMessage = "Read-only session"
+
+// inputOpcodes are dropped from the client while read-only
+var inputOpcodes = map[string]bool{
+	protocol.OpMouse: true,
+	protocol.OpKey:   true,
+	protocol.OpTouch: true,
+}
+
+// streamOpcodes open a stream from the client to guacd, the value is the index of the stream argument
+var streamOpcodes = map[string]int{
+	protocol.OpClipboard: 0,
+	protocol.OpFile:      0,
+	protocol.OpPipe:      0,
+	protocol.OpArgv:      0,
+	protocol.OpPut:       1,
+}
+
+// openedStream returns the stream opened by instr if it is one of streamOpcodes
+func openedStream(values []string) (int, bool) {
+	index, ok := streamOpcodes[values[0]]
+	if !ok || len(values) < index+2 {
+		return 0, false
+	}
+	stream, err := strconv.Atoi(values[index+1])
+	return stream, err == nil
+}
+
+// WithReadOnly makes the tunnel itself drop input from the client, independent of protocol.WithReadOnly
+// which relies on the protocol plugin of guacd. Use Tunnel.SetReadOnly to change it while forwarding
+func WithReadOnly() TunnelOption {
+	return func(t *Tunnel) {
+		t.readOnly.Store(true)
+	}
+}
+
+// SetReadOnly enables or disables dropping of client input on a live tunnel, e.g. when an admin takes control
+func (t *Tunnel) SetReadOnly(readOnly bool) {
+	t.readOnly.Store(readOnly)
+}
+
+// ReadOnly reports whether client input is currently dropped by the tunnel
+func (t *Tunnel) ReadOnly() bool {
+	return t.readOnly.Load()
+}
+
+// enforceReadOnly is the first client middleware of every tunnel. While read-only it drops mouse, key and touch,
+// refuses streams opened by the client with an ack and drops their blob and end instructions.
+// Streams refused while read-only stay refused until they end, even if read-only is disabled meanwhile
+func (t *Tunnel) enforceReadOnly(ctx context.Context, instr protocol.Instruction) (protocol.Instruction, bool, error) {
+	readOnly := t.readOnly.Load()
+	if !readOnly && len(t.refusedStreams) == 0 {
+		return instr, true, nil
+	}
+	opcode := instr.Opcode().Value()
+	if readOnly && inputOpcodes[opcode] {
+		return "", false, nil
+	}
+	if opcode != protocol.OpBlob && opcode != protocol.OpEnd && !readOnly {
+		return instr, true, nil
+	}
+	values, err := instr.Values()
+	if err != nil {
+		return "", false, err
+	}
+	switch opcode {
+	case protocol.OpBlob, protocol.OpEnd:
+		if len(values) < 2 {
+			return instr, true, nil
+		}
+		stream, err := strconv.Atoi(values[1])
+		if err != nil {
+			return instr, true, nil
+		}
+		if !readOnly && !t.refusedStreams[stream] {
+			return instr, true, nil
+		}
+		if opcode == protocol.OpEnd {
+			delete(t.refusedStreams, stream)
+		}
+		return "", false, nil
+	}
+	stream, ok := openedStream(values)
+	if !ok {
+		return instr, true, nil
+	}
+	if t.refusedStreams == nil {
+		t.refusedStreams = make(map[int]bool)
+	}
+	t.refusedStreams[stream] = true
+	ack := protocol.Ack{Stream: stream, Message: readOnlyMessage, Status: protocol.ClientForbidden}.Marshal()
+	return "", false, t.WriteToClient(ack)
+}
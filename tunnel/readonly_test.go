@@ -0,0 +1,47 @@
+package tunnel
+
+import (
+	"context"
+	"testing"
+
+	"github.com/riete/go-guac/protocol"
+)
+
+func TestEnforceReadOnly(t *testing.T) {
+	browser, client := NewPipeTransport()
+	tun := NewTunnelWithTransport(nil, client, WithReadOnly())
+	pass := func(instr protocol.Instruction) bool {
+		t.Helper()
+		out, err := tun.clientMiddleware.apply(context.Background(), instr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out == instr
+	}
+
+	key := protocol.Key{Keysym: 65, Pressed: true}.Marshal()
+	size := protocol.Size{Width: 800, Height: 600}.Marshal()
+	if pass(key) || pass(protocol.Mouse{X: 1, Y: 1}.Marshal()) {
+		t.Fatal("input passed while read-only")
+	}
+	if !pass(size) {
+		t.Fatal("size dropped while read-only")
+	}
+
+	if pass(protocol.Clipboard{Stream: 3, Mimetype: "text/plain"}.Marshal()) {
+		t.Fatal("clipboard passed while read-only")
+	}
+	want := protocol.Ack{Stream: 3, Message: readOnlyMessage, Status: protocol.ClientForbidden}.Marshal()
+	if ack, err := browser.ReadInstruction(); err != nil || ack != want {
+		t.Fatalf("got %q %v, want %q", ack, err, want)
+	}
+
+	// the refused stream stays refused after taking control, other input passes again
+	tun.SetReadOnly(false)
+	if pass(protocol.NewBlob(3, []byte("secret")).Marshal()) || pass(protocol.End{Stream: 3}.Marshal()) {
+		t.Fatal("refused stream passed after read-only was disabled")
+	}
+	if !pass(key) || !pass(protocol.NewBlob(3, []byte("new stream")).Marshal()) {
+		t.Fatal("input dropped after read-only was disabled")
+	}
+}
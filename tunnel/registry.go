@@ -0,0 +1,123 @@
+package tunnel
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// session is a connected tunnel with the metadata it was registered with
+type session struct {
+	tunnel  *Tunnel
+	user    string
+	started time.Time
+	done    chan struct{} // closed when the tunnel is removed
+}
+
+func newSession(t *Tunnel, user string) *session {
+	return &session{tunnel: t, user: user, started: time.Now(), done: make(chan struct{})}
+}
+
+// Registry tracks the connected tunnels by connection ID, the tunnel owning a connection together with the tunnels
+// which joined it. Owners can be joined by other users with protocol.WithJoinConnection
+type Registry struct {
+	mu       sync.RWMutex
+	sessions map[string][]*session
+}
+
+func (r *Registry) add(connId string, s *session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[connId] = append(r.sessions[connId], s)
+}
+
+func (r *Registry) remove(connId string, t *Tunnel) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sessions := r.sessions[connId]
+	for i, s := range sessions {
+		if s.tunnel == t {
+			close(s.done)
+			sessions = append(sessions[:i], sessions[i+1:]...)
+			break
+		}
+	}
+	if len(sessions) == 0 {
+		delete(r.sessions, connId)
+	} else {
+		r.sessions[connId] = sessions
+	}
+}
+
+// owner returns the session owning the connection, r.mu must be held
+func (r *Registry) owner(connId string) (*session, bool) {
+	for _, s := range r.sessions[connId] {
+		if !s.tunnel.joined {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// Lookup returns the tunnel owning the connection
+func (r *Registry) Lookup(connId string) (*Tunnel, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, exists := r.owner(connId)
+	if !exists {
+		return nil, false
+	}
+	return s.tunnel, true
+}
+
+// Tunnels returns the tunnels of the connection, the owner and every tunnel which joined it
+func (r *Registry) Tunnels(connId string) []*Tunnel {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tunnels := make([]*Tunnel, 0, len(r.sessions[connId]))
+	for _, s := range r.sessions[connId] {
+		tunnels = append(tunnels, s.tunnel)
+	}
+	return tunnels
+}
+
+// ConnIds returns the sorted IDs of all active connections which have an owner
+func (r *Registry) ConnIds() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	connIds := make([]string, 0, len(r.sessions))
+	for connId := range r.sessions {
+		if _, exists := r.owner(connId); exists {
+			connIds = append(connIds, connId)
+		}
+	}
+	sort.Strings(connIds)
+	return connIds
+}
+
+// all returns every registered session
+func (r *Registry) all() []*session {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var sessions []*session
+	for _, connSessions := range r.sessions {
+		sessions = append(sessions, connSessions...)
+	}
+	return sessions
+}
+
+func NewRegistry() *Registry {
+	return &Registry{sessions: make(map[string][]*session)}
+}
+
+// WithRegistry registers the tunnel in r once connected and removes it on disconnect
+func WithRegistry(r *Registry) TunnelOption {
+	return func(t *Tunnel) {
+		WithOnConnect(func(connId string) {
+			r.add(connId, newSession(t, ""))
+		})(t)
+		WithOnDisconnect(func(connId string) {
+			r.remove(connId, t)
+		})(t)
+	}
+}
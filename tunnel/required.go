@@ -0,0 +1,96 @@
+package tunnel
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/riete/go-guac/protocol"
+)
+
+// requiredStream is the stream index used for argv streams answering required instructions.
+// guacd accepts at most 64 input streams per user and guacamole-common-js allocates them from 0 upwards,
+// so the highest index does not collide with streams opened by the browser in practice
+const requiredStream = 63
+
+// RequiredHandler is called when guacd sends a required instruction, e.g. username/password/domain
+// if NLA credentials are missing. connId is empty if it happens during the handshake.
+// It returns the values of the parameters it can answer, the others are forwarded to the browser
+type RequiredHandler func(connId string, parameters []string) map[string]string
+
+// WithRequiredHandler answers required instructions with argv streams built from the values returned by f.
+// If forward is false, parameters f cannot answer end the tunnel with CLIENT_UNAUTHORIZED
+// instead of prompting the user in the browser. Prompts during the handshake are never forwarded,
+// as the browser's answer is only read once Forward started
+func WithRequiredHandler(f RequiredHandler, forward bool) TunnelOption {
+	return func(t *Tunnel) {
+		t.requiredHandler = f
+		t.forwardRequired = forward
+	}
+}
+
+// argvInstruction builds the argv stream setting the connection parameter name to value
+func argvInstruction(name, value string) protocol.Instruction {
+	return protocol.Argv{Stream: requiredStream, Mimetype: "text/plain", Name: name}.Marshal() +
+		protocol.NewBlob(requiredStream, []byte(value)).Marshal() +
+		protocol.End{Stream: requiredStream}.Marshal()
+}
+
+// isRequiredAck reports whether instr acknowledges an argv stream opened by handleRequired which is still outstanding,
+// the browser does not know the stream so it is not forwarded. Acks for streams of the browser pass through
+func (t *Tunnel) isRequiredAck(instr protocol.Instruction) bool {
+	if t.pendingArgv == 0 || instr.Opcode().Value() != protocol.OpAck {
+		return false
+	}
+	var ack protocol.Ack
+	if ack.Unmarshal(instr) != nil || ack.Stream != requiredStream {
+		return false
+	}
+	t.pendingArgv--
+	return true
+}
+
+// handleRequired answers required with the values of the RequiredHandler. During the handshake config is not nil,
+// its connect arguments answer the remaining parameters and anything still missing fails the handshake,
+// since nothing reads the browser's answer before Forward. Otherwise missing parameters are forwarded to the browser
+func (t *Tunnel) handleRequired(instr protocol.Instruction, config *protocol.HandshakeConfig) error {
+	var required protocol.Required
+	if err := required.Unmarshal(instr); err != nil {
+		return fmt.Errorf("parse required instruction error: %s", err.Error())
+	}
+	var values map[string]string
+	if t.requiredHandler != nil {
+		values = t.requiredHandler(t.ConnId(), required.Parameters)
+	}
+	var answer protocol.Instruction
+	var answered int
+	var missing []string
+	for _, name := range required.Parameters {
+		value, ok := values[name]
+		if !ok && config != nil {
+			value = config.ConnectArg(name)
+			ok = value != ""
+		}
+		if ok {
+			answer += argvInstruction(name, value)
+			answered++
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	if answer != "" {
+		if err := t.writeGuacd(answer.Byte()); err != nil {
+			return fmt.Errorf("write argv instruction to guacd error: %s", err.Error())
+		}
+		t.pendingArgv += answered
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if !t.forwardRequired || config != nil {
+		return protocol.NewError(protocol.ClientUnauthorized, "required parameters not provided: "+strings.Join(missing, ", "))
+	}
+	if err := t.client.WriteInstruction(protocol.Required{Parameters: missing}.Marshal()); err != nil {
+		return fmt.Errorf("write required instruction to ws error: %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/riete/go-guac/protocol"
+)
+
+// requireCredentials answers connect with required username and password, then expects both as argv streams
+// and acknowledges them before sending ready
+func requireCredentials(conn net.Conn, d *protocol.Decoder, argv chan<- protocol.Argv) bool {
+	_, _ = d.Decode() // select
+	_, _ = conn.Write(protocol.NewInstruction("args", "VERSION_1_5_0", "hostname", "username", "password").Byte())
+	for {
+		instr, err := d.Decode()
+		if err != nil {
+			return false
+		}
+		if instr.Opcode().Value() == protocol.OpConnect {
+			break
+		}
+	}
+	_, _ = conn.Write(protocol.Required{Parameters: []string{"username", "password"}}.Marshal().Byte())
+	for received := 0; received < 2; {
+		instr, err := d.Decode()
+		if err != nil {
+			return false
+		}
+		if instr.Opcode().Value() == protocol.OpArgv {
+			var a protocol.Argv
+			_ = a.Unmarshal(instr)
+			argv <- a
+			received++
+		}
+	}
+	_, err := conn.Write(protocol.NewInstruction("ready", "$conn").Byte())
+	return err == nil
+}
+
+func TestHandshakeRequired(t *testing.T) {
+	t.Run("answered", func(t *testing.T) {
+		guacdClient, guacdServer := net.Pipe()
+		argv := make(chan protocol.Argv, 2)
+		fakeGuacd(t, guacdServer, func(d *protocol.Decoder) bool {
+			if !requireCredentials(guacdServer, d, argv) {
+				return false
+			}
+			// acks for the argv streams, then one for a stream of the browser with the same index
+			ack := protocol.Ack{Stream: requiredStream, Message: "OK", Status: protocol.Success}.Marshal()
+			_, err := guacdServer.Write((ack + ack + ack).Byte())
+			return err == nil
+		})
+		browser, client := NewPipeTransport()
+		tun := NewTunnelWithTransport(guacdClient, client, WithRequiredHandler(func(connId string, parameters []string) map[string]string {
+			return map[string]string{"password": "secret"}
+		}, true))
+		t.Cleanup(tun.Close)
+		config := protocol.NewHandshakeConfig(nil, protocol.WithAuth("admin", ""))
+		if err := tun.Handshake(config); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{"username", "password"} {
+			if a := <-argv; a.Name != want {
+				t.Fatalf("got argv %q, want %q", a.Name, want)
+			}
+		}
+		go func() { _ = tun.Forward(context.Background()) }()
+		_, _ = browser.ReadInstruction() // tunnel uuid
+		instr, err := browser.ReadInstruction()
+		if err != nil || instr.Opcode().Value() != protocol.OpAck {
+			t.Fatalf("got %q %v, want the ack of the browser's stream", instr, err)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		guacdClient, guacdServer := net.Pipe()
+		fakeGuacd(t, guacdServer, func(d *protocol.Decoder) bool {
+			return requireCredentials(guacdServer, d, make(chan protocol.Argv, 2))
+		})
+		_, client := NewPipeTransport()
+		tun := NewTunnelWithTransport(guacdClient, client)
+		t.Cleanup(tun.Close)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := tun.HandshakeContext(ctx, protocol.NewHandshakeConfig(nil))
+		var guacErr *protocol.Error
+		if !errors.As(err, &guacErr) || guacErr.Status != protocol.ClientUnauthorized {
+			t.Fatalf("got %v, want client unauthorized", err)
+		}
+	})
+}
@@ -0,0 +1,120 @@
+package tunnel
+
+import (
+	"context"
+	"time"
+
+	"github.com/riete/go-guac/protocol"
+)
+
+const defaultGracePeriod = 5 * time.Second
+
+// WithGracePeriod is how long a graceful stop waits for guacd and the client to close their side
+// before the connections are closed, default 5s
+func WithGracePeriod(d time.Duration) TunnelOption {
+	return func(t *Tunnel) {
+		if d > 0 {
+			t.gracePeriod = d
+		}
+	}
+}
+
+// WithStopStatus sets what the client is told when forwarding stops because the context of Forward is done.
+// protocol.Success sends disconnect, which is the default, any other status an error instruction with message
+func WithStopStatus(status protocol.StatusCode, message string) TunnelOption {
+	return func(t *Tunnel) {
+		t.stopStatus = status
+		t.stopMessage = message
+	}
+}
+
+// Shutdown stops forwarding gracefully, telling the client status and message as WithStopStatus does,
+// and waits until Forward returned or ctx is done. Forward returns a *protocol.Error unless status is protocol.Success.
+// If the tunnel is not forwarding, the client is told and the tunnel closed right away
+func (t *Tunnel) Shutdown(ctx context.Context, status protocol.StatusCode, message string) error {
+	t.mu.Lock()
+	t.stopStatus, t.stopMessage = status, message
+	t.stopRequested = true
+	cancel, done := t.cancel, t.done
+	t.mu.Unlock()
+	if cancel == nil {
+		_ = t.WriteToClient(stopInstruction(status, message))
+		t.Close()
+		return nil
+	}
+	cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func stopInstruction(status protocol.StatusCode, message string) protocol.Instruction {
+	if status == protocol.Success {
+		return protocol.Disconnect
+	}
+	return protocol.NewError(status, message).Marshal()
+}
+
+// stop ends forwarding once the context of Forward is done. If neither side failed, the client is told the stop status
+// and guacd is disconnected, so both close their connections and the forwarding goroutines return on their own.
+// The stop status only applies if the stop was requested by Shutdown, expiry or the done parent context,
+// otherwise a side closed normally and the client is told disconnect.
+// Connections still open after the grace period, or right away if a side failed or Close was called, are closed,
+// which also ends writes of the stop instructions blocked on a side no longer reading.
+// Stream state of the filters is released and recorders are flushed once all goroutines returned
+func (t *Tunnel) stop(parentDone bool) error {
+	t.mu.Lock()
+	closing := t.closing
+	graceful := t.err == nil && !closing
+	status, message := protocol.Success, ""
+	if t.stopRequested || parentDone {
+		status, message = t.stopStatus, t.stopMessage
+	}
+	if graceful && status != protocol.Success {
+		t.err = protocol.NewError(status, message)
+	}
+	err := t.err
+	t.mu.Unlock()
+
+	exited := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(exited)
+	}()
+	told := make(chan struct{})
+	if graceful {
+		// either side may have stopped reading, so the writes are bounded by the grace period as well
+		go func() {
+			defer close(told)
+			_ = t.WriteToClient(stopInstruction(status, message))
+			_ = t.WriteToGuacd(protocol.Disconnect)
+		}()
+		select {
+		case <-exited:
+		case <-time.After(t.gracePeriod):
+		}
+	} else {
+		close(told)
+	}
+	if closing {
+		// Close limited how long this write may block
+		_ = t.WriteToGuacd(protocol.Disconnect)
+	}
+	_ = t.guacd.Close()
+	_ = t.client.Close()
+	<-exited
+	<-told
+
+	for _, cleanup := range t.cleanups {
+		cleanup()
+	}
+	if !t.joined {
+		for _, f := range t.flushers {
+			_ = f.Flush(t.ConnId())
+		}
+	}
+	return err
+}
@@ -0,0 +1,182 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/riete/go-guac/protocol"
+)
+
+// flushRecorder counts flushes, Replay is not used by tunnels
+type flushRecorder struct {
+	mu      sync.Mutex
+	flushed []string
+}
+
+func (r *flushRecorder) Record(connId string, data []byte) {}
+
+func (r *flushRecorder) Replay(ctx context.Context, connId string) (chan string, error) {
+	return nil, errors.New("not supported")
+}
+
+func (r *flushRecorder) Close(connId string) {}
+
+func (r *flushRecorder) Flush(connId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.flushed = append(r.flushed, connId)
+	return nil
+}
+
+func TestForwardGracefulStop(t *testing.T) {
+	guacdClient, guacdServer := net.Pipe()
+	disconnected := make(chan struct{})
+	fakeGuacd(t, guacdServer, func(d *protocol.Decoder) bool {
+		if !acceptHandshake(guacdServer, d, protocol.NewInstruction("ready", "$conn")) {
+			return false
+		}
+		for {
+			instr, err := d.Decode()
+			if err != nil {
+				return false
+			}
+			if instr == protocol.Disconnect {
+				close(disconnected)
+				return false
+			}
+		}
+	})
+
+	rec := &flushRecorder{}
+	browser, client := NewPipeTransport()
+	tun := NewTunnelWithTransport(guacdClient, client,
+		WithRecorder(rec),
+		WithStopStatus(protocol.ServerBusy, "maintenance"),
+		WithGracePeriod(100*time.Millisecond),
+	)
+	defer tun.Close()
+	if err := tun.Handshake(protocol.NewHandshakeConfig(nil)); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	forwarded := make(chan error, 1)
+	go func() { forwarded <- tun.Forward(ctx) }()
+	_, _ = browser.ReadInstruction() // tunnel uuid
+
+	cancel()
+	want := protocol.NewError(protocol.ServerBusy, "maintenance").Marshal()
+	if instr, err := browser.ReadInstruction(); err != nil || instr != want {
+		t.Fatalf("got %q %v, want %q", instr, err, want)
+	}
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("guacd not disconnected")
+	}
+	// the browser never closes its side, the grace period ends forwarding
+	select {
+	case err := <-forwarded:
+		var guacErr *protocol.Error
+		if !errors.As(err, &guacErr) || guacErr.Status != protocol.ServerBusy {
+			t.Fatalf("Forward returned %v, want server busy", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Forward did not return after the grace period")
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.flushed) != 1 || rec.flushed[0] != "$conn" {
+		t.Fatalf("recorder flushed %q, want $conn", rec.flushed)
+	}
+}
+
+// TestForwardClientEOFIgnoresStopStatus closes the browser side, which is a normal end and not a stop with the stop status
+func TestForwardClientEOFIgnoresStopStatus(t *testing.T) {
+	guacdClient, guacdServer := net.Pipe()
+	fakeGuacd(t, guacdServer, func(d *protocol.Decoder) bool {
+		return acceptHandshake(guacdServer, d, protocol.NewInstruction("ready", "$conn"))
+	})
+	browser, client := NewPipeTransport()
+	tun := NewTunnelWithTransport(guacdClient, client,
+		WithStopStatus(protocol.ServerBusy, "maintenance"),
+		WithGracePeriod(10*time.Millisecond),
+	)
+	defer tun.Close()
+	if err := tun.Handshake(protocol.NewHandshakeConfig(nil)); err != nil {
+		t.Fatal(err)
+	}
+	forwarded := make(chan error, 1)
+	go func() { forwarded <- tun.Forward(context.Background()) }()
+	_, _ = browser.ReadInstruction() // tunnel uuid
+
+	_ = browser.Close()
+	select {
+	case err := <-forwarded:
+		if err != nil {
+			t.Fatalf("Forward returned %v after the client closed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Forward did not return")
+	}
+}
+
+// stalledTransport is a client which stopped reading, its writes block until it is closed
+type stalledTransport struct {
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (s *stalledTransport) ReadInstruction() (protocol.Instruction, error) {
+	<-s.closed
+	return "", io.EOF
+}
+
+func (s *stalledTransport) WriteInstruction(instr protocol.Instruction) error {
+	<-s.closed
+	return io.ErrClosedPipe
+}
+
+func (s *stalledTransport) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *stalledTransport) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+// TestForwardGracefulStopStalledClient stops forwarding to a client which does not read, the grace period bounds the stop
+func TestForwardGracefulStopStalledClient(t *testing.T) {
+	guacdClient, guacdServer := net.Pipe()
+	fakeGuacd(t, guacdServer, func(d *protocol.Decoder) bool {
+		if !acceptHandshake(guacdServer, d, protocol.NewInstruction("ready", "$conn")) {
+			return false
+		}
+		// keeps the tunnel blocked writing to the client
+		_, _ = guacdServer.Write(protocol.NewInstruction(protocol.OpSync, "1").Byte())
+		return true
+	})
+	tun := NewTunnelWithTransport(guacdClient, &stalledTransport{closed: make(chan struct{})},
+		WithGracePeriod(100*time.Millisecond),
+	)
+	defer tun.Close()
+	if err := tun.Handshake(protocol.NewHandshakeConfig(nil)); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	forwarded := make(chan error, 1)
+	go func() { forwarded <- tun.Forward(ctx) }()
+	time.Sleep(10 * time.Millisecond)
+
+	cancel()
+	select {
+	case <-forwarded:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Forward did not return after the grace period")
+	}
+}
@@ -0,0 +1,170 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/riete/go-guac/protocol"
+)
+
+// maxClientInstructionLength limits the instructions read from clients to the length guacamole-client accepts,
+// guacamole-common-js splits blobs into chunks below it
+const maxClientInstructionLength = 8192
+
+// ClientTransport carries instructions between the tunnel and the client, usually a browser
+// running guacamole-common-js. WriteInstruction and Ping may be called concurrently with each other and with ReadInstruction
+type ClientTransport interface {
+	// ReadInstruction blocks until the next instruction from the client is available,
+	// it returns io.EOF once the client closed the connection normally
+	ReadInstruction() (protocol.Instruction, error)
+	// WriteInstruction sends one or more concatenated instructions to the client
+	WriteInstruction(instr protocol.Instruction) error
+	// Ping checks that the client is alive, returning an error if it does not answer before ctx is done
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// UUIDSender is implemented by transports whose client expects the tunnel UUID as first instruction, "0.,36.<uuid>;",
+// as Guacamole.WebSocketTunnel does. Forward only sends it if SendsUUID returns true,
+// Guacamole.HTTPTunnel for example reads the UUID from the response of connect instead
+type UUIDSender interface {
+	SendsUUID() bool
+}
+
+// messageReader joins the messages of a message based connection into one stream,
+// so that the decoder does not depend on how instructions are split into messages
+type messageReader struct {
+	next func() (io.Reader, error)
+	r    io.Reader
+}
+
+func (m *messageReader) Read(p []byte) (int, error) {
+	for {
+		if m.r == nil {
+			r, err := m.next()
+			if err != nil {
+				return 0, err
+			}
+			m.r = r
+		}
+		n, err := m.r.Read(p)
+		if err == io.EOF {
+			m.r = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// connTransport speaks the plain Guacamole protocol over a stream connection
+type connTransport struct {
+	conn    net.Conn
+	decoder *protocol.Decoder
+}
+
+func (c *connTransport) ReadInstruction() (protocol.Instruction, error) {
+	return c.decoder.Decode()
+}
+
+func (c *connTransport) WriteInstruction(instr protocol.Instruction) error {
+	_, err := c.conn.Write(instr.Byte())
+	return err
+}
+
+// Ping writes a nop, the plain protocol has no way to request an answer
+func (c *connTransport) Ping(ctx context.Context) error {
+	return c.WriteInstruction(protocol.Nop)
+}
+
+func (c *connTransport) Close() error {
+	return c.conn.Close()
+}
+
+// NewConnTransport uses a stream connection such as TCP as client transport
+func NewConnTransport(conn net.Conn) ClientTransport {
+	return &connTransport{conn: conn, decoder: protocol.NewDecoder(conn, protocol.WithMaxInstructionLength(maxClientInstructionLength))}
+}
+
+const pipeBufferSize = 64
+
+// pipe is shared by both ends of an in-memory transport
+type pipe struct {
+	closed chan struct{}
+	once   sync.Once
+}
+
+type pipeTransport struct {
+	*pipe
+	in  <-chan protocol.Instruction
+	out chan<- protocol.Instruction
+}
+
+// ReadInstruction returns instructions written before the pipe was closed, then io.EOF
+func (p *pipeTransport) ReadInstruction() (protocol.Instruction, error) {
+	select {
+	case instr := <-p.in:
+		return instr, nil
+	case <-p.closed:
+		select {
+		case instr := <-p.in:
+			return instr, nil
+		default:
+			return "", io.EOF
+		}
+	}
+}
+
+// WriteInstruction splits concatenated instructions, so the other end reads them one by one
+func (p *pipeTransport) WriteInstruction(instr protocol.Instruction) error {
+	decoder := protocol.NewDecoder(strings.NewReader(string(instr)))
+	for {
+		next, err := decoder.Decode()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		select {
+		case p.out <- next:
+		case <-p.closed:
+			return io.ErrClosedPipe
+		}
+	}
+}
+
+// SendsUUID returns true, the pipe stands in for a WebSocket client
+func (p *pipeTransport) SendsUUID() bool {
+	return true
+}
+
+func (p *pipeTransport) Ping(ctx context.Context) error {
+	select {
+	case <-p.closed:
+		return io.ErrClosedPipe
+	default:
+		return nil
+	}
+}
+
+// Close closes both ends
+func (p *pipeTransport) Close() error {
+	p.once.Do(func() {
+		close(p.closed)
+	})
+	return nil
+}
+
+// NewPipeTransport returns two connected in-memory transports, e.g. to drive a tunnel from a test.
+// Instructions written to one end are read from the other
+func NewPipeTransport() (ClientTransport, ClientTransport) {
+	p := &pipe{closed: make(chan struct{})}
+	a, b := make(chan protocol.Instruction, pipeBufferSize), make(chan protocol.Instruction, pipeBufferSize)
+	return &pipeTransport{pipe: p, in: a, out: b}, &pipeTransport{pipe: p, in: b, out: a}
+}
@@ -0,0 +1,117 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	coderws "github.com/coder/websocket"
+	"github.com/gorilla/websocket"
+	"github.com/riete/go-guac/protocol"
+)
+
+const pingWriteTimeout = time.Second
+
+// websocketTransport adapts a gorilla/websocket connection
+type websocketTransport struct {
+	conn    *websocket.Conn
+	decoder *protocol.Decoder
+	mu      sync.Mutex // gorilla supports one concurrent writer
+	pong    chan struct{}
+}
+
+func (w *websocketTransport) ReadInstruction() (protocol.Instruction, error) {
+	return w.decoder.Decode()
+}
+
+func (w *websocketTransport) WriteInstruction(instr protocol.Instruction) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.conn.WriteMessage(websocket.TextMessage, instr.Byte())
+}
+
+func (w *websocketTransport) SendsUUID() bool {
+	return true
+}
+
+// Ping sends a ping control frame and waits for the pong, which is handled while ReadInstruction is reading
+func (w *websocketTransport) Ping(ctx context.Context) error {
+	// drop a late pong of a previous ping
+	select {
+	case <-w.pong:
+	default:
+	}
+	if err := w.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(pingWriteTimeout)); err != nil {
+		return err
+	}
+	select {
+	case <-w.pong:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *websocketTransport) Close() error {
+	return w.conn.Close()
+}
+
+// NewWebsocketTransport adapts a gorilla/websocket connection, it replaces the pong handler of conn
+func NewWebsocketTransport(conn *websocket.Conn) ClientTransport {
+	w := &websocketTransport{conn: conn, pong: make(chan struct{}, 1)}
+	w.decoder = protocol.NewDecoder(&messageReader{next: func() (io.Reader, error) {
+		_, r, err := conn.NextReader()
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+			return nil, io.EOF
+		}
+		return r, err
+	}}, protocol.WithMaxInstructionLength(maxClientInstructionLength))
+	conn.SetPongHandler(func(string) error {
+		select {
+		case w.pong <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+	return w
+}
+
+// coderWebsocketTransport adapts a github.com/coder/websocket (formerly nhooyr.io/websocket) connection
+type coderWebsocketTransport struct {
+	conn    *coderws.Conn
+	decoder *protocol.Decoder
+}
+
+func (c *coderWebsocketTransport) ReadInstruction() (protocol.Instruction, error) {
+	return c.decoder.Decode()
+}
+
+func (c *coderWebsocketTransport) WriteInstruction(instr protocol.Instruction) error {
+	return c.conn.Write(context.Background(), coderws.MessageText, instr.Byte())
+}
+
+func (c *coderWebsocketTransport) SendsUUID() bool {
+	return true
+}
+
+func (c *coderWebsocketTransport) Ping(ctx context.Context) error {
+	return c.conn.Ping(ctx)
+}
+
+func (c *coderWebsocketTransport) Close() error {
+	return c.conn.Close(coderws.StatusNormalClosure, "")
+}
+
+// NewCoderWebsocketTransport adapts a github.com/coder/websocket connection
+func NewCoderWebsocketTransport(conn *coderws.Conn) ClientTransport {
+	c := &coderWebsocketTransport{conn: conn}
+	c.decoder = protocol.NewDecoder(&messageReader{next: func() (io.Reader, error) {
+		_, r, err := conn.Reader(context.Background())
+		if status := coderws.CloseStatus(err); status == coderws.StatusNormalClosure || status == coderws.StatusGoingAway {
+			return nil, io.EOF
+		}
+		return r, err
+	}}, protocol.WithMaxInstructionLength(maxClientInstructionLength))
+	return c
+}
@@ -1,23 +1,26 @@
 package tunnel
 
 import (
-	"bufio"
 	"context"
-	"errors"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
-	"github.com/riete/convert/str"
 	"github.com/riete/go-guac/protocol"
 	"github.com/riete/go-guac/recorder"
 )
 
 const minKeepaliveInterval = 30 * time.Second
 
+// closeWriteTimeout limits writing disconnect to guacd in Close
+const closeWriteTimeout = time.Second
+
 type TunnelOption func(t *Tunnel)
 
 func WithOnConnect(f func(string)) TunnelOption {
@@ -90,18 +93,63 @@ func WithWsKeepalive(interval time.Duration, threshold int64) TunnelOption {
 	}
 }
 
+// WithRecorder records the data received from guacd. Tunnels joining an existing connection are not recorded,
+// as they share the connection ID with the recording of the owner
 func WithRecorder(r recorder.Recorder) TunnelOption {
 	return func(t *Tunnel) {
-		WithOnReadFromGuacd(r.Record)(t)
-		WithOnDisconnect(r.Close)(t)
+		WithOnReadFromGuacd(func(connId string, data []byte) {
+			if !t.joined {
+				r.Record(connId, data)
+			}
+		})(t)
+		if f, ok := r.(recorder.Flusher); ok {
+			t.flushers = append(t.flushers, f)
+		}
+		WithOnDisconnect(func(connId string) {
+			if !t.joined {
+				r.Close(connId)
+			}
+		})(t)
 	}
 }
 
 type Tunnel struct {
+	uuid                   string
 	guacd                  net.Conn
-	ws                     *websocket.Conn
+	guacdMu                sync.Mutex        // serialises writes to guacd
+	decoder                *protocol.Decoder // shared by Handshake and Forward, so no buffered data is lost in between
+	client                 ClientTransport
+	mu                     sync.Mutex // guards err, connId, cancel, done, closing and the stop status
+	closeOnce              sync.Once
 	err                    error
+	cancel                 context.CancelFunc
+	done                   chan struct{} // closed when Forward returns
+	wg                     sync.WaitGroup
+	gracePeriod            time.Duration
+	stopStatus             protocol.StatusCode
+	stopMessage            string
+	stopRequested          bool // set by Shutdown and expiry, the stop status only applies to stops they requested
+	closing                bool // set by Close, forwarding stops without a grace period
+	flushers               []recorder.Flusher
+	cleanups               []func() // release the stream state of filters once forwarding stopped
+	idleTimeout            time.Duration
+	maxDuration            time.Duration
+	warnBefore             time.Duration
+	expiryWarning          ExpiryWarning
+	lastInput              atomic.Int64 // unix nanoseconds of the last mouse, key or touch from the client
 	connId                 string
+	protocolVersion        protocol.ProtocolVersion
+	joined                 bool
+	protocol               string
+	hostname               string
+	bytesIn                atomic.Int64 // from the client to guacd
+	bytesOut               atomic.Int64 // from guacd to the client
+	instructionsIn         atomic.Int64
+	instructionsOut        atomic.Int64
+	handshakeLatency       atomic.Int64
+	roundTrip              atomic.Int64
+	syncs                  syncTracker
+	metrics                Metrics
 	guacdKeepaliveInterval time.Duration
 	wsKeepaliveInterval    time.Duration
 	wsKeepaliveThreshold   int64
@@ -109,133 +157,215 @@ type Tunnel struct {
 	onReadFromGuacd        func(connId string, fromGuacd []byte)
 	onReadFromWs           func(connId string, fromWs []byte)
 	onDisconnect           func(connId string)
+	requiredHandler        RequiredHandler
+	forwardRequired        bool
+	pendingArgv            int // argv streams answering required not yet acknowledged by guacd, only used while reading guacd
+	guacdMiddleware        middlewareChain
+	clientMiddleware       middlewareChain
+	readOnly               atomic.Bool
+	refusedStreams         map[int]bool // client streams refused while read-only, only used by wsToGuacd
+	clipboard              *clipboardFilter
+	files                  *fileFilter
 }
 
-// Handshake performs the complete handshake process.
-// The handshake flow is:
-//  1. Client sends "select" with the protocol name (vnc, rdp, ssh)
-//  2. Server responds with "args" listing required parameters
-//  3. Client sends "size" with display dimensions
-//  4. Client sends "audio" with supported audio MIME types
-//  5. Client sends "video" with supported video MIME types
-//  6. Client sends "image" with supported image MIME types
-//  7. Client sends "connect" with parameter values (in order from args)
-//  8. Server responds with "ready" containing the connection ID
-func (t *Tunnel) Handshake(config *protocol.HandshakeConfig) error {
-	br := bufio.NewReader(t.guacd)
-	if _, err := t.guacd.Write(config.SelectInstruction().Byte()); err != nil {
-		return fmt.Errorf("send select instruction error: %s", err.Error())
-	}
-	selectResponse, err := br.ReadString(';')
-	if err != nil && err != io.EOF {
-		return fmt.Errorf("read select instruction response error: %s", err.Error())
-	}
-	argsInstr := protocol.Instruction(selectResponse)
-	if err = argsInstr.Error(); err != nil {
-		return err
-	}
-
-	fullConnectInstr := config.SizeInstruction() + config.AudioInstruction() + config.VideoInstruction() +
-		config.ImageInstruction() + config.ConnectInstruction(argsInstr.Args())
-	if _, err = t.guacd.Write(fullConnectInstr.Byte()); err != nil {
-		return fmt.Errorf("send full connect instruction error: %s", err.Error())
-	}
-	connectResponse, err := br.ReadString(';')
-	if err != nil && err != io.EOF {
-		return fmt.Errorf("read connect instruction response error: %s", err.Error())
-	}
-	readyInstr := protocol.Instruction(connectResponse)
-	if err = readyInstr.Error(); err != nil {
-		return err
-	}
-	if len(readyInstr.Args()) == 0 {
-		return errors.New("no connection ID received")
-	}
-	t.connId = readyInstr.Args()[0].Value()
-	if t.onConnect != nil {
-		t.onConnect(t.connId)
-	}
-	return nil
+// UUID identifies the tunnel to the client. Unlike ConnId it is unique for every tunnel joining the same connection
+func (t *Tunnel) UUID() string {
+	return t.uuid
 }
 
 func (t *Tunnel) ConnId() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	return t.connId
 }
 
+// Joined reports whether the tunnel joined an existing connection rather than creating it
+func (t *Tunnel) Joined() bool {
+	return t.joined
+}
+
+// Protocol returns the protocol of the config passed to Handshake, e.g. rdp
+func (t *Tunnel) Protocol() string {
+	return t.protocol
+}
+
+// ProtocolVersion returns the protocol version negotiated with guacd during Handshake
+func (t *Tunnel) ProtocolVersion() protocol.ProtocolVersion {
+	return t.protocolVersion
+}
+
+// Close disconnects from guacd and closes both connections. A running Forward is stopped without a grace period
+// and waited for, so Close must not be called from callbacks or middlewares, cancel the context of Forward instead.
+// Calling Close more than once has no effect
 func (t *Tunnel) Close() {
-	_, _ = t.guacd.Write(protocol.Disconnect.Byte())
-	_ = t.guacd.Close()
-	_ = t.ws.Close()
-	if t.onDisconnect != nil {
-		t.onDisconnect(t.connId)
-	}
-	t.connId = ""
+	t.closeOnce.Do(func() {
+		t.mu.Lock()
+		t.closing = true
+		cancel, done := t.cancel, t.done
+		t.mu.Unlock()
+		// do not wait behind a write blocked on an unresponsive guacd for long
+		_ = t.guacd.SetWriteDeadline(time.Now().Add(closeWriteTimeout))
+		if cancel != nil {
+			// Forward disconnects guacd and closes the connections once its context is done
+			cancel()
+			<-done
+		} else {
+			_ = t.writeGuacd(protocol.Disconnect.Byte())
+		}
+		_ = t.guacd.Close()
+		_ = t.client.Close()
+
+		t.mu.Lock()
+		connId := t.connId
+		t.connId = ""
+		t.mu.Unlock()
+		if t.onDisconnect != nil {
+			t.onDisconnect(connId)
+		}
+	})
+}
+
+// writeGuacd writes to guacd, serialised with the writes of all other goroutines
+func (t *Tunnel) writeGuacd(b []byte) error {
+	t.guacdMu.Lock()
+	defer t.guacdMu.Unlock()
+	_, err := t.guacd.Write(b)
+	return err
 }
 
 func (t *Tunnel) setError(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	if t.err == nil {
 		t.err = err
 	}
 }
 
+// setIOError records a failed read or write unless ctx is done, as stopping closes the connections
+func (t *Tunnel) setIOError(ctx context.Context, err error) {
+	if ctx.Err() == nil {
+		t.setError(err)
+	}
+}
+
+func (t *Tunnel) getError() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
 func (t *Tunnel) guacdToWs(ctx context.Context, cancel context.CancelFunc) {
 	defer cancel()
-	br := bufio.NewReader(t.guacd)
-	var once sync.Once
+	connId := t.ConnId()
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		default:
-			b, err := br.ReadBytes(';')
+			instr, err := t.decoder.Decode()
 			if err == io.EOF {
 				return
 			}
 			if err != nil {
-				t.setError(fmt.Errorf("read data from guacd error: %s", err.Error()))
+				t.setIOError(ctx, fmt.Errorf("read data from guacd error: %s", err.Error()))
 				return
 			}
-			once.Do(func() {
-				// check first instruction after handshake, maybe some error, e.g. CLIENT_UNAUTHORIZED
-				instr := protocol.Instruction(str.FromBytes(b))
-				if err = instr.Error(); err != nil {
+			// guacd reports errors such as CLIENT_UNAUTHORIZED right before closing the connection
+			if err = instr.Error(); err != nil {
+				t.setError(err)
+			}
+			if instr.Opcode().Value() == protocol.OpRequired {
+				if err = t.handleRequired(instr, nil); err != nil {
 					t.setError(err)
+					return
 				}
-			})
+				continue
+			}
+			if t.isRequiredAck(instr) {
+				continue
+			}
+			out, err := t.guacdMiddleware.apply(ctx, instr)
+			if err != nil {
+				t.setError(fmt.Errorf("guacd middleware error: %s", err.Error()))
+				return
+			}
+			if out == "" {
+				continue
+			}
+			b := out.Byte()
 			if t.onReadFromGuacd != nil {
-				t.onReadFromGuacd(t.connId, b)
+				t.onReadFromGuacd(connId, b)
 			}
-			if err = t.ws.WriteMessage(websocket.TextMessage, b); err != nil {
-				t.setError(fmt.Errorf("write data to ws error: %s", err.Error()))
+			if err = t.client.WriteInstruction(out); err != nil {
+				t.setIOError(ctx, fmt.Errorf("write data to ws error: %s", err.Error()))
 				return
 			}
+			t.forwarded(GuacdToClient, out, out != instr)
 		}
 	}
 }
 
 func (t *Tunnel) wsToGuacd(ctx context.Context, cancel context.CancelFunc) {
 	defer cancel()
+	connId := t.ConnId()
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		default:
-			_, data, err := t.ws.ReadMessage()
+			instr, err := t.client.ReadInstruction()
+			if err == io.EOF {
+				return
+			}
 			if err != nil {
-				t.setError(fmt.Errorf("read data from ws error: %s", err.Error()))
+				t.setIOError(ctx, fmt.Errorf("read data from ws error: %s", err.Error()))
 				return
 			}
+			if isInput(instr) {
+				t.lastInput.Store(time.Now().UnixNano())
+			}
+			if instr.IsInternal() {
+				if err = t.handleInternal(instr); err != nil {
+					t.setError(err)
+					return
+				}
+				continue
+			}
+			out, err := t.clientMiddleware.apply(ctx, instr)
+			if err != nil {
+				t.setError(fmt.Errorf("client middleware error: %s", err.Error()))
+				return
+			}
+			if out == "" {
+				continue
+			}
+			data := out.Byte()
 			if t.onReadFromWs != nil {
-				t.onReadFromWs(t.connId, data)
+				t.onReadFromWs(connId, data)
 			}
-			if _, err = t.guacd.Write(data); err != nil {
-				t.setError(fmt.Errorf("write data to guacd error: %s", err.Error()))
+			if err = t.writeGuacd(data); err != nil {
+				t.setIOError(ctx, fmt.Errorf("write data to guacd error: %s", err.Error()))
 				return
 			}
+			t.forwarded(ClientToGuacd, out, out != instr)
 		}
 	}
 }
 
+// handleInternal answers internal instructions of guacamole-common-js, which are not meant for guacd
+func (t *Tunnel) handleInternal(instr protocol.Instruction) error {
+	values, err := instr.Values()
+	if err != nil {
+		return fmt.Errorf("read internal instruction error: %s", err.Error())
+	}
+	if len(values) > 1 && values[1] == protocol.InternalPing {
+		if err = t.client.WriteInstruction(instr); err != nil {
+			return fmt.Errorf("write data to ws error: %s", err.Error())
+		}
+	}
+	return nil
+}
+
 func (t *Tunnel) guacdKeepalive(ctx context.Context) {
 	ticker := time.NewTicker(t.guacdKeepaliveInterval)
 	defer ticker.Stop()
@@ -244,50 +374,122 @@ func (t *Tunnel) guacdKeepalive(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			_, _ = t.guacd.Write(protocol.Nop.Byte())
+			_ = t.writeGuacd(protocol.Nop.Byte())
 		}
 	}
 }
 
-func (t *Tunnel) wsKeepalive(ctx context.Context) {
+// wsKeepalive pings the client every interval, giving up after threshold consecutive pings were not answered
+func (t *Tunnel) wsKeepalive(ctx context.Context, cancel context.CancelFunc) {
 	ticker := time.NewTicker(t.wsKeepaliveInterval)
 	defer ticker.Stop()
-	deadline := t.wsKeepaliveInterval * time.Duration(t.wsKeepaliveThreshold)
-	_ = t.ws.SetReadDeadline(time.Now().Add(deadline))
-	originalPongHandler := t.ws.PongHandler()
-	t.ws.SetPongHandler(func(appData string) error {
-		_ = t.ws.SetReadDeadline(time.Now().Add(deadline))
-		return originalPongHandler(appData)
-	})
+	var failures int64
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			_ = t.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+			pingCtx, pingCancel := context.WithTimeout(ctx, t.wsKeepaliveInterval)
+			err := t.client.Ping(pingCtx)
+			pingCancel()
+			if err == nil {
+				failures = 0
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if failures++; failures >= t.wsKeepaliveThreshold {
+				t.setError(fmt.Errorf("ws keepalive error: %s", err.Error()))
+				cancel()
+				return
+			}
 		}
 	}
 }
 
-func (t *Tunnel) Forward(ctx context.Context) error {
-	newCtx, cancel := context.WithCancel(ctx)
+// Forward sends the tunnel UUID to clients of a UUIDSender transport and forwards instructions in both directions
+// until ctx is done or either side fails
+func (t *Tunnel) Forward(ctx context.Context) (err error) {
+	if s, ok := t.client.(UUIDSender); ok && s.SendsUUID() {
+		if err = t.client.WriteInstruction(protocol.NewInstruction(protocol.OpInternal, t.uuid)); err != nil {
+			return fmt.Errorf("write tunnel uuid error: %s", err.Error())
+		}
+	}
+	newCtx, cancel := context.WithCancel(context.WithValue(ctx, tunnelContextKey{}, t))
+	defer cancel()
+	done := make(chan struct{})
+	defer close(done)
+	t.mu.Lock()
+	t.cancel, t.done = cancel, done
+	t.mu.Unlock()
+	if t.metrics != nil {
+		started := time.Now()
+		t.metrics.SessionStarted(t)
+		defer func() {
+			t.metrics.SessionEnded(t, time.Since(started), CloseStatus(err))
+		}()
+	}
+
 	if t.guacdKeepaliveInterval > 0 {
-		go t.guacdKeepalive(newCtx)
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			t.guacdKeepalive(newCtx)
+		}()
 	}
 	if t.wsKeepaliveInterval > 0 {
-		go t.wsKeepalive(newCtx)
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			t.wsKeepalive(newCtx, cancel)
+		}()
 	}
-	go t.guacdToWs(newCtx, cancel)
-	go t.wsToGuacd(newCtx, cancel)
+	if t.idleTimeout > 0 || t.maxDuration > 0 {
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			t.watchExpiry(newCtx, cancel)
+		}()
+	}
+	t.wg.Add(2)
+	go func() {
+		defer t.wg.Done()
+		t.guacdToWs(newCtx, cancel)
+	}()
+	go func() {
+		defer t.wg.Done()
+		t.wsToGuacd(newCtx, cancel)
+	}()
 	<-newCtx.Done()
-	return t.err
+	return t.stop(ctx.Err() != nil)
+}
+
+// newUUID returns a random version 4 UUID
+func newUUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	s := hex.EncodeToString(b[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
 }
 
 func NewTunnel(guacd net.Conn, ws *websocket.Conn, opts ...TunnelOption) *Tunnel {
+	return NewTunnelWithTransport(guacd, NewWebsocketTransport(ws), opts...)
+}
+
+// NewTunnelWithTransport creates a tunnel between guacd and a client connected through any ClientTransport
+func NewTunnelWithTransport(guacd net.Conn, client ClientTransport, opts ...TunnelOption) *Tunnel {
 	t := &Tunnel{
-		guacd: guacd,
-		ws:    ws,
+		uuid:            newUUID(),
+		guacd:           guacd,
+		decoder:         protocol.NewDecoder(guacd),
+		client:          client,
+		forwardRequired: true,
+		gracePeriod:     defaultGracePeriod,
 	}
+	t.clientMiddleware = middlewareChain{t.enforceReadOnly}
 	for _, opt := range opts {
 		opt(t)
 	}
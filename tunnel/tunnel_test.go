@@ -0,0 +1,335 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/riete/go-guac/protocol"
+)
+
+// serveTunnel starts a WebSocket server running a tunnel to guacd for every connection
+// and returns the browser side of the first connection
+func serveTunnel(t *testing.T, guacd net.Conn, opts ...TunnelOption) *websocket.Conn {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		tun := NewTunnel(guacd, ws, opts...)
+		defer tun.Close()
+		if err = tun.Handshake(protocol.NewHandshakeConfig(nil)); err != nil {
+			t.Error(err)
+			return
+		}
+		_ = tun.Forward(context.Background())
+	}))
+	t.Cleanup(server.Close)
+	browser, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = browser.Close() })
+	return browser
+}
+
+// TestNoDataLostAfterHandshake makes guacd send the first frame in the same write as ready,
+// so it is already buffered when the handshake completes
+func TestNoDataLostAfterHandshake(t *testing.T) {
+	client, server := net.Pipe()
+	sync := protocol.NewInstruction("sync", "1700000000000")
+	fakeGuacd(t, server, func(d *protocol.Decoder) bool {
+		return acceptHandshake(server, d, protocol.NewInstruction("ready", "$conn")+sync)
+	})
+
+	browser := serveTunnel(t, client)
+	_ = browser.SetReadDeadline(time.Now().Add(5 * time.Second))
+	// the tunnel UUID comes first
+	if _, _, err := browser.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	_, data, err := browser.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(sync) {
+		t.Fatalf("got %q, want %q", data, sync)
+	}
+}
+
+func TestForwardPipeTransport(t *testing.T) {
+	guacdClient, guacdServer := net.Pipe()
+	key := protocol.Key{Keysym: 65307, Pressed: true}.Marshal()
+	received := make(chan protocol.Instruction, 1)
+	fakeGuacd(t, guacdServer, func(d *protocol.Decoder) bool {
+		if !acceptHandshake(guacdServer, d, protocol.NewInstruction("ready", "$conn")) {
+			return false
+		}
+		_, _ = guacdServer.Write(protocol.NewInstruction("sync", "1").Byte())
+		instr, err := d.Decode()
+		if err == nil {
+			received <- instr
+		}
+		return err == nil
+	})
+
+	browser, client := NewPipeTransport()
+	tun := NewTunnelWithTransport(guacdClient, client)
+	defer tun.Close()
+	if err := tun.Handshake(protocol.NewHandshakeConfig(nil)); err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = tun.Forward(context.Background()) }()
+
+	if instr, err := browser.ReadInstruction(); err != nil || !instr.IsInternal() || len(instr.Args()) != 1 {
+		t.Fatalf("got %q %v, want tunnel uuid", instr, err)
+	}
+	if instr, err := browser.ReadInstruction(); err != nil || instr.Opcode().Value() != protocol.OpSync {
+		t.Fatalf("got %q %v, want sync", instr, err)
+	}
+	if err := browser.WriteInstruction(key); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case instr := <-received:
+		if instr != key {
+			t.Fatalf("got %q, want %q", instr, key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("key not forwarded to guacd")
+	}
+}
+
+func TestInternalPing(t *testing.T) {
+	guacdClient, guacdServer := net.Pipe()
+	received := make(chan protocol.Instruction, 1)
+	fakeGuacd(t, guacdServer, func(d *protocol.Decoder) bool {
+		if !acceptHandshake(guacdServer, d, protocol.NewInstruction("ready", "$conn")) {
+			return false
+		}
+		instr, err := d.Decode()
+		if err == nil {
+			received <- instr
+		}
+		return err == nil
+	})
+
+	browser, client := NewPipeTransport()
+	tun := NewTunnelWithTransport(guacdClient, client)
+	defer tun.Close()
+	if err := tun.Handshake(protocol.NewHandshakeConfig(nil)); err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = tun.Forward(context.Background()) }()
+
+	want := protocol.NewInstruction(protocol.OpInternal, tun.UUID())
+	if instr, err := browser.ReadInstruction(); err != nil || instr != want {
+		t.Fatalf("got %q %v, want %q", instr, err, want)
+	}
+	ping := protocol.NewInstruction(protocol.OpInternal, protocol.InternalPing, "1700000000000")
+	if err := browser.WriteInstruction(ping); err != nil {
+		t.Fatal(err)
+	}
+	if instr, err := browser.ReadInstruction(); err != nil || instr != ping {
+		t.Fatalf("got %q %v, want %q", instr, err, ping)
+	}
+	// guacd sees the next instruction, not the ping
+	if err := browser.WriteInstruction(protocol.Nop); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case instr := <-received:
+		if instr != protocol.Nop {
+			t.Fatalf("got %q, want %q", instr, protocol.Nop)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nop not forwarded to guacd")
+	}
+}
+
+// TestCloseDuringForward closes a busy tunnel from several goroutines, run with -race
+func TestCloseDuringForward(t *testing.T) {
+	guacdClient, guacdServer := net.Pipe()
+	go func() {
+		defer guacdServer.Close()
+		d := protocol.NewDecoder(guacdServer)
+		if !acceptHandshake(guacdServer, d, protocol.NewInstruction("ready", "$conn")) {
+			return
+		}
+		go func() {
+			for {
+				if _, err := d.Decode(); err != nil {
+					return
+				}
+			}
+		}()
+		for {
+			if _, err := guacdServer.Write(protocol.NewInstruction("sync", "1").Byte()); err != nil {
+				return
+			}
+		}
+	}()
+
+	var disconnects atomic.Int32
+	var returned atomic.Bool
+	browser, client := NewPipeTransport()
+	tun := NewTunnelWithTransport(guacdClient, client,
+		WithOnReadFromGuacd(func(connId string, data []byte) {
+			if returned.Load() {
+				t.Error("guacd data forwarded after Forward returned")
+			}
+		}),
+		WithOnDisconnect(func(connId string) { disconnects.Add(1) }),
+	)
+	if err := tun.Handshake(protocol.NewHandshakeConfig(nil)); err != nil {
+		t.Fatal(err)
+	}
+	forwarded := make(chan struct{})
+	go func() {
+		_ = tun.Forward(context.Background())
+		returned.Store(true)
+		close(forwarded)
+	}()
+	go func() {
+		for {
+			if err := browser.WriteInstruction(protocol.Key{Keysym: 65, Pressed: true}.Marshal()); err != nil {
+				return
+			}
+			if _, err := browser.ReadInstruction(); err != nil {
+				return
+			}
+		}
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tun.Close()
+			_ = tun.ConnId()
+		}()
+	}
+	wg.Wait()
+	select {
+	case <-forwarded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Forward did not return after Close")
+	}
+	if n := disconnects.Load(); n != 1 {
+		t.Fatalf("onDisconnect called %d times, want 1", n)
+	}
+}
+
+// TestForwardClientCloses makes the browser close the WebSocket normally, which is not an error
+func TestForwardClientCloses(t *testing.T) {
+	guacdClient, guacdServer := net.Pipe()
+	fakeGuacd(t, guacdServer, func(d *protocol.Decoder) bool {
+		return acceptHandshake(guacdServer, d, protocol.NewInstruction("ready", "$conn"))
+	})
+	forwarded := make(chan error, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		tun := NewTunnel(guacdClient, ws, WithGracePeriod(10*time.Millisecond))
+		defer tun.Close()
+		if err = tun.Handshake(protocol.NewHandshakeConfig(nil)); err != nil {
+			t.Error(err)
+			return
+		}
+		forwarded <- tun.Forward(context.Background())
+	}))
+	defer server.Close()
+	browser, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer browser.Close()
+	if _, _, err = browser.ReadMessage(); err != nil { // tunnel uuid
+		t.Fatal(err)
+	}
+	closeMessage := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	if err = browser.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-forwarded:
+		if err != nil {
+			t.Fatalf("Forward returned %v after a normal close", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Forward did not return")
+	}
+}
+
+// slowCloseTransport delays closing the client, so the guacd side notices a closed connection first
+type slowCloseTransport struct {
+	ClientTransport
+}
+
+func (s slowCloseTransport) Close() error {
+	time.Sleep(50 * time.Millisecond)
+	return s.ClientTransport.Close()
+}
+
+// TestCloseReportsNoError closes a forwarding tunnel, the connections it closes are not reported as failures
+func TestCloseReportsNoError(t *testing.T) {
+	guacdClient, guacdServer := net.Pipe()
+	fakeGuacd(t, guacdServer, func(d *protocol.Decoder) bool {
+		if !acceptHandshake(guacdServer, d, protocol.NewInstruction("ready", "$conn")) {
+			return false
+		}
+		// keep the connection open after disconnect, only the tunnel closes it
+		for {
+			if _, err := d.Decode(); err != nil {
+				return false
+			}
+		}
+	})
+	_, client := NewPipeTransport()
+	tun := NewTunnelWithTransport(guacdClient, slowCloseTransport{client})
+	if err := tun.Handshake(protocol.NewHandshakeConfig(nil)); err != nil {
+		t.Fatal(err)
+	}
+	forwarded := make(chan error, 1)
+	go func() { forwarded <- tun.Forward(context.Background()) }()
+	time.Sleep(10 * time.Millisecond)
+
+	tun.Close()
+	select {
+	case err := <-forwarded:
+		if err != nil {
+			t.Fatalf("Forward returned %v after Close", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Forward did not return after Close")
+	}
+}
+
+// TestClientInstructionTooLong makes the client send an instruction longer than clients are allowed to
+func TestClientInstructionTooLong(t *testing.T) {
+	browser, server := net.Pipe()
+	defer browser.Close()
+	client := NewConnTransport(server)
+	defer client.Close()
+	go func() {
+		_, _ = browser.Write(protocol.NewInstruction(protocol.OpBlob, "0", strings.Repeat("A", maxClientInstructionLength)).Byte())
+	}()
+	if _, err := client.ReadInstruction(); !errors.Is(err, protocol.ErrInstructionTooLong) {
+		t.Fatalf("got %v, want %v", err, protocol.ErrInstructionTooLong)
+	}
+}